@@ -0,0 +1,110 @@
+package spb
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+type aliasKey struct {
+	deviceID string
+	name     string
+}
+
+type aliasTable struct {
+	mu      sync.Mutex
+	next    uint64
+	byKey   map[aliasKey]uint64
+	byAlias map[uint64]aliasKey
+}
+
+func newAliasTable() *aliasTable {
+	return &aliasTable{
+		byKey:   make(map[aliasKey]uint64),
+		byAlias: make(map[uint64]aliasKey),
+	}
+}
+
+func (t *aliasTable) assign(deviceID, name string) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := aliasKey{deviceID: deviceID, name: name}
+	if alias, ok := t.byKey[key]; ok {
+		return alias
+	}
+
+	for {
+		if _, taken := t.byAlias[t.next]; !taken {
+			break
+		}
+		t.next++
+	}
+
+	alias := t.next
+	t.next++
+	t.byKey[key] = alias
+	t.byAlias[alias] = key
+
+	return alias
+}
+
+func (t *aliasTable) reserve(deviceID, name string, alias uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := aliasKey{deviceID: deviceID, name: name}
+	if existing, ok := t.byKey[key]; ok && existing != alias {
+		return fmt.Errorf("metric %s already has alias %d", name, existing)
+	}
+
+	if owner, ok := t.byAlias[alias]; ok && owner != key {
+		return fmt.Errorf("alias %d is already assigned to metric %s", alias, owner.name)
+	}
+
+	t.byKey[key] = alias
+	t.byAlias[alias] = key
+
+	return nil
+}
+
+func (t *aliasTable) set(deviceID, name string, alias uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := aliasKey{deviceID: deviceID, name: name}
+	t.byKey[key] = alias
+	t.byAlias[alias] = key
+}
+
+func (t *aliasTable) lookup(deviceID, name string) (uint64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	alias, ok := t.byKey[aliasKey{deviceID: deviceID, name: name}]
+	return alias, ok
+}
+
+func (t *aliasTable) resolve(alias uint64) (string, string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key, ok := t.byAlias[alias]
+	return key.deviceID, key.name, ok
+}
+
+func (t *aliasTable) resolveMetric(deviceID string, metric *sproto.Payload_Metric) bool {
+	if metric.Name != nil || metric.Alias == nil {
+		return true
+	}
+
+	aliasDeviceID, name, ok := t.resolve(metric.GetAlias())
+	if !ok || aliasDeviceID != deviceID {
+		return false
+	}
+
+	metric.Name = proto.String(name)
+	return true
+}
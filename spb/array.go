@@ -0,0 +1,362 @@
+package spb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+)
+
+type UInt8Array []uint8
+
+func isArrayDataType(dataType sproto.DataType) bool {
+	return dataType >= sproto.DataType_Int8Array && dataType <= sproto.DataType_DateTimeArray
+}
+
+func inferArrayDataType(value any) (sproto.DataType, bool) {
+	switch value.(type) {
+	case []int8:
+		return sproto.DataType_Int8Array, true
+	case []int16:
+		return sproto.DataType_Int16Array, true
+	case []int32:
+		return sproto.DataType_Int32Array, true
+	case []int64, []int:
+		return sproto.DataType_Int64Array, true
+	case UInt8Array:
+		return sproto.DataType_UInt8Array, true
+	case []uint16:
+		return sproto.DataType_UInt16Array, true
+	case []uint32:
+		return sproto.DataType_UInt32Array, true
+	case []uint64, []uint:
+		return sproto.DataType_UInt64Array, true
+	case []float32:
+		return sproto.DataType_FloatArray, true
+	case []float64:
+		return sproto.DataType_DoubleArray, true
+	case []bool:
+		return sproto.DataType_BooleanArray, true
+	case []string:
+		return sproto.DataType_StringArray, true
+	case []time.Time:
+		return sproto.DataType_DateTimeArray, true
+	default:
+		return sproto.DataType_Unknown, false
+	}
+}
+
+func encodeArray(dataType sproto.DataType, value any) ([]byte, bool) {
+	switch dataType {
+	case sproto.DataType_Int8Array:
+		v, ok := value.([]int8)
+		if !ok {
+			return nil, false
+		}
+		buf := make([]byte, len(v))
+		for i, x := range v {
+			buf[i] = byte(x)
+		}
+		return buf, true
+
+	case sproto.DataType_Int16Array:
+		v, ok := value.([]int16)
+		if !ok {
+			return nil, false
+		}
+		buf := make([]byte, 2*len(v))
+		for i, x := range v {
+			binary.LittleEndian.PutUint16(buf[2*i:], uint16(x))
+		}
+		return buf, true
+
+	case sproto.DataType_Int32Array:
+		v, ok := value.([]int32)
+		if !ok {
+			return nil, false
+		}
+		buf := make([]byte, 4*len(v))
+		for i, x := range v {
+			binary.LittleEndian.PutUint32(buf[4*i:], uint32(x))
+		}
+		return buf, true
+
+	case sproto.DataType_Int64Array:
+		var v []int64
+		switch x := value.(type) {
+		case []int64:
+			v = x
+		case []int:
+			v = make([]int64, len(x))
+			for i := range x {
+				v[i] = int64(x[i])
+			}
+		default:
+			return nil, false
+		}
+		buf := make([]byte, 8*len(v))
+		for i, x := range v {
+			binary.LittleEndian.PutUint64(buf[8*i:], uint64(x))
+		}
+		return buf, true
+
+	case sproto.DataType_UInt8Array:
+		switch v := value.(type) {
+		case UInt8Array:
+			return append([]byte(nil), v...), true
+		case []byte:
+			return append([]byte(nil), v...), true
+		}
+		return nil, false
+
+	case sproto.DataType_UInt16Array:
+		v, ok := value.([]uint16)
+		if !ok {
+			return nil, false
+		}
+		buf := make([]byte, 2*len(v))
+		for i, x := range v {
+			binary.LittleEndian.PutUint16(buf[2*i:], x)
+		}
+		return buf, true
+
+	case sproto.DataType_UInt32Array:
+		v, ok := value.([]uint32)
+		if !ok {
+			return nil, false
+		}
+		buf := make([]byte, 4*len(v))
+		for i, x := range v {
+			binary.LittleEndian.PutUint32(buf[4*i:], x)
+		}
+		return buf, true
+
+	case sproto.DataType_UInt64Array:
+		var v []uint64
+		switch x := value.(type) {
+		case []uint64:
+			v = x
+		case []uint:
+			v = make([]uint64, len(x))
+			for i := range x {
+				v[i] = uint64(x[i])
+			}
+		default:
+			return nil, false
+		}
+		buf := make([]byte, 8*len(v))
+		for i, x := range v {
+			binary.LittleEndian.PutUint64(buf[8*i:], x)
+		}
+		return buf, true
+
+	case sproto.DataType_FloatArray:
+		v, ok := value.([]float32)
+		if !ok {
+			return nil, false
+		}
+		buf := make([]byte, 4*len(v))
+		for i, x := range v {
+			binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(x))
+		}
+		return buf, true
+
+	case sproto.DataType_DoubleArray:
+		v, ok := value.([]float64)
+		if !ok {
+			return nil, false
+		}
+		buf := make([]byte, 8*len(v))
+		for i, x := range v {
+			binary.LittleEndian.PutUint64(buf[8*i:], math.Float64bits(x))
+		}
+		return buf, true
+
+	case sproto.DataType_BooleanArray:
+		v, ok := value.([]bool)
+		if !ok {
+			return nil, false
+		}
+		buf := make([]byte, 4+(len(v)+7)/8)
+		binary.LittleEndian.PutUint32(buf, uint32(len(v)))
+		for i, x := range v {
+			if x {
+				buf[4+i/8] |= 0x80 >> (i % 8)
+			}
+		}
+		return buf, true
+
+	case sproto.DataType_StringArray:
+		v, ok := value.([]string)
+		if !ok {
+			return nil, false
+		}
+		var buf bytes.Buffer
+		for _, x := range v {
+			if bytes.IndexByte([]byte(x), 0) >= 0 {
+				return nil, false
+			}
+			buf.WriteString(x)
+			buf.WriteByte(0)
+		}
+		return buf.Bytes(), true
+
+	case sproto.DataType_DateTimeArray:
+		v, ok := value.([]time.Time)
+		if !ok {
+			return nil, false
+		}
+		buf := make([]byte, 8*len(v))
+		for i, x := range v {
+			binary.LittleEndian.PutUint64(buf[8*i:], uint64(x.UnixMilli()))
+		}
+		return buf, true
+
+	default:
+		return nil, false
+	}
+}
+
+func decodeArray(dataType sproto.DataType, buf []byte) (any, error) {
+	switch dataType {
+	case sproto.DataType_Int8Array:
+		v := make([]int8, len(buf))
+		for i := range v {
+			v[i] = int8(buf[i])
+		}
+		return v, nil
+
+	case sproto.DataType_Int16Array:
+		if err := checkArrayLength(dataType, buf, 2); err != nil {
+			return nil, err
+		}
+		v := make([]int16, len(buf)/2)
+		for i := range v {
+			v[i] = int16(binary.LittleEndian.Uint16(buf[2*i:]))
+		}
+		return v, nil
+
+	case sproto.DataType_Int32Array:
+		if err := checkArrayLength(dataType, buf, 4); err != nil {
+			return nil, err
+		}
+		v := make([]int32, len(buf)/4)
+		for i := range v {
+			v[i] = int32(binary.LittleEndian.Uint32(buf[4*i:]))
+		}
+		return v, nil
+
+	case sproto.DataType_Int64Array:
+		if err := checkArrayLength(dataType, buf, 8); err != nil {
+			return nil, err
+		}
+		v := make([]int64, len(buf)/8)
+		for i := range v {
+			v[i] = int64(binary.LittleEndian.Uint64(buf[8*i:]))
+		}
+		return v, nil
+
+	case sproto.DataType_UInt8Array:
+		return UInt8Array(append([]byte(nil), buf...)), nil
+
+	case sproto.DataType_UInt16Array:
+		if err := checkArrayLength(dataType, buf, 2); err != nil {
+			return nil, err
+		}
+		v := make([]uint16, len(buf)/2)
+		for i := range v {
+			v[i] = binary.LittleEndian.Uint16(buf[2*i:])
+		}
+		return v, nil
+
+	case sproto.DataType_UInt32Array:
+		if err := checkArrayLength(dataType, buf, 4); err != nil {
+			return nil, err
+		}
+		v := make([]uint32, len(buf)/4)
+		for i := range v {
+			v[i] = binary.LittleEndian.Uint32(buf[4*i:])
+		}
+		return v, nil
+
+	case sproto.DataType_UInt64Array:
+		if err := checkArrayLength(dataType, buf, 8); err != nil {
+			return nil, err
+		}
+		v := make([]uint64, len(buf)/8)
+		for i := range v {
+			v[i] = binary.LittleEndian.Uint64(buf[8*i:])
+		}
+		return v, nil
+
+	case sproto.DataType_FloatArray:
+		if err := checkArrayLength(dataType, buf, 4); err != nil {
+			return nil, err
+		}
+		v := make([]float32, len(buf)/4)
+		for i := range v {
+			v[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+		}
+		return v, nil
+
+	case sproto.DataType_DoubleArray:
+		if err := checkArrayLength(dataType, buf, 8); err != nil {
+			return nil, err
+		}
+		v := make([]float64, len(buf)/8)
+		for i := range v {
+			v[i] = math.Float64frombits(binary.LittleEndian.Uint64(buf[8*i:]))
+		}
+		return v, nil
+
+	case sproto.DataType_BooleanArray:
+		if len(buf) < 4 {
+			return nil, fmt.Errorf("%s value is missing its element count", dataType)
+		}
+		count := int(binary.LittleEndian.Uint32(buf))
+		if len(buf)-4 < (count+7)/8 {
+			return nil, fmt.Errorf("%s value holds %d bytes, too short for %d elements", dataType, len(buf)-4, count)
+		}
+		v := make([]bool, count)
+		for i := range v {
+			v[i] = buf[4+i/8]&(0x80>>(i%8)) != 0
+		}
+		return v, nil
+
+	case sproto.DataType_StringArray:
+		if len(buf) > 0 && buf[len(buf)-1] != 0 {
+			return nil, fmt.Errorf("%s value is not null terminated", dataType)
+		}
+		v := make([]string, 0)
+		for len(buf) > 0 {
+			end := bytes.IndexByte(buf, 0)
+			v = append(v, string(buf[:end]))
+			buf = buf[end+1:]
+		}
+		return v, nil
+
+	case sproto.DataType_DateTimeArray:
+		if err := checkArrayLength(dataType, buf, 8); err != nil {
+			return nil, err
+		}
+		v := make([]time.Time, len(buf)/8)
+		for i := range v {
+			v[i] = time.UnixMilli(int64(binary.LittleEndian.Uint64(buf[8*i:]))).UTC()
+		}
+		return v, nil
+
+	default:
+		return nil, fmt.Errorf("%s is not an array datatype", dataType)
+	}
+}
+
+func checkArrayLength(dataType sproto.DataType, buf []byte, size int) error {
+	if len(buf)%size != 0 {
+		return fmt.Errorf("%s value holds %d bytes, not a multiple of %d", dataType, len(buf), size)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package spb
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+)
+
+func TestArrayEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		dataType sproto.DataType
+		value    any
+		want     []byte
+	}{
+		{"int8", sproto.DataType_Int8Array, []int8{-23, 123}, []byte{0xE9, 0x7B}},
+		{"int16", sproto.DataType_Int16Array, []int16{-30000, 30000}, []byte{0xD0, 0x8A, 0x30, 0x75}},
+		{"int32", sproto.DataType_Int32Array, []int32{-1, 315338746}, []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFA, 0xAF, 0xCB, 0x12}},
+		{"int64", sproto.DataType_Int64Array, []int64{-1, 1}, []byte{
+			0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+			0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		}},
+		{"uint8", sproto.DataType_UInt8Array, UInt8Array{23, 250}, []byte{0x17, 0xFA}},
+		{"uint16", sproto.DataType_UInt16Array, []uint16{30, 52360}, []byte{0x1E, 0x00, 0x88, 0xCC}},
+		{"uint32", sproto.DataType_UInt32Array, []uint32{52, 3293969225}, []byte{0x34, 0x00, 0x00, 0x00, 0x49, 0xFB, 0x55, 0xC4}},
+		{"float", sproto.DataType_FloatArray, []float32{1.23}, []byte{0xA4, 0x70, 0x9D, 0x3F}},
+		{"boolean", sproto.DataType_BooleanArray,
+			[]bool{false, false, true, true, false, true, false, false, true, true, false, true},
+			[]byte{0x0C, 0x00, 0x00, 0x00, 0x34, 0xD0}},
+		{"empty boolean", sproto.DataType_BooleanArray, []bool{}, []byte{0x00, 0x00, 0x00, 0x00}},
+		{"string", sproto.DataType_StringArray, []string{"ABC", "hello"},
+			[]byte{0x41, 0x42, 0x43, 0x00, 0x68, 0x65, 0x6C, 0x6C, 0x6F, 0x00}},
+		{"datetime", sproto.DataType_DateTimeArray,
+			[]time.Time{time.UnixMilli(1256102875335).UTC(), time.UnixMilli(1656107875000).UTC()},
+			[]byte{
+				0xC7, 0xD0, 0x90, 0x75, 0x24, 0x01, 0x00, 0x00,
+				0xB8, 0xBA, 0xB8, 0x97, 0x81, 0x01, 0x00, 0x00,
+			}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metric, err := NewMetric(tt.name, tt.dataType, tt.value)
+			if err != nil {
+				t.Fatalf("NewMetric() error = %v", err)
+			}
+
+			if got := metric.GetBytesValue(); !bytes.Equal(got, tt.want) {
+				t.Fatalf("bytes_value = % X, want % X", got, tt.want)
+			}
+
+			got, err := MetricValue(metric)
+			if err != nil {
+				t.Fatalf("MetricValue() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.value) {
+				t.Errorf("MetricValue() = %#v, want %#v", got, tt.value)
+			}
+		})
+	}
+}
+
+func TestArrayInference(t *testing.T) {
+	tests := []struct {
+		value any
+		want  sproto.DataType
+	}{
+		{[]int8{1}, sproto.DataType_Int8Array},
+		{[]int{1}, sproto.DataType_Int64Array},
+		{UInt8Array{1}, sproto.DataType_UInt8Array},
+		{[]uint{1}, sproto.DataType_UInt64Array},
+		{[]float64{1}, sproto.DataType_DoubleArray},
+		{[]bool{true}, sproto.DataType_BooleanArray},
+		{[]string{"a"}, sproto.DataType_StringArray},
+		{[]time.Time{{}}, sproto.DataType_DateTimeArray},
+		{[]byte{1}, sproto.DataType_Bytes},
+	}
+
+	for _, tt := range tests {
+		metric, err := ToMetric("array", tt.value)
+		if err != nil {
+			t.Fatalf("ToMetric(%T) error = %v", tt.value, err)
+		}
+
+		if got := sproto.DataType(metric.GetDatatype()); got != tt.want {
+			t.Errorf("ToMetric(%T) datatype = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestArrayDecodingErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		dataType sproto.DataType
+		buf      []byte
+	}{
+		{"odd int16 length", sproto.DataType_Int16Array, []byte{0x01, 0x02, 0x03}},
+		{"short double", sproto.DataType_DoubleArray, []byte{0x01, 0x02, 0x03, 0x04}},
+		{"boolean without count", sproto.DataType_BooleanArray, []byte{0x01}},
+		{"boolean count too large", sproto.DataType_BooleanArray, []byte{0x09, 0x00, 0x00, 0x00, 0xFF}},
+		{"unterminated string", sproto.DataType_StringArray, []byte{0x41, 0x42}},
+		{"partial datetime", sproto.DataType_DateTimeArray, make([]byte, 12)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeArray(tt.dataType, tt.buf); err == nil {
+				t.Errorf("decodeArray() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestStringArrayRejectsNul(t *testing.T) {
+	if _, err := NewMetric("names", sproto.DataType_StringArray, []string{"a\x00b"}); err == nil {
+		t.Errorf("NewMetric() error = nil, want an error for an embedded NUL")
+	}
+}
@@ -0,0 +1,109 @@
+package spb
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type BdSeqStore interface {
+	Load() (uint64, bool, error)
+	Save(bdSeq uint64) error
+}
+
+type FileBdSeqStore struct {
+	path string
+}
+
+func NewFileBdSeqStore(path string) *FileBdSeqStore {
+	return &FileBdSeqStore{path: path}
+}
+
+func (s *FileBdSeqStore) Load() (uint64, bool, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read bdSeq from %s: %w", s.path, err)
+	}
+
+	bdSeq, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to parse bdSeq in %s: %w", s.path, err)
+	}
+
+	if bdSeq > 255 {
+		return 0, false, fmt.Errorf("bdSeq %d in %s is out of range", bdSeq, s.path)
+	}
+
+	return bdSeq, true, nil
+}
+
+func (s *FileBdSeqStore) Save(bdSeq uint64) error {
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(strconv.FormatUint(bdSeq, 10)+"\n"), 0o644); err != nil {
+		return fmt.Errorf("failed to write bdSeq to %s: %w", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to write bdSeq to %s: %w", s.path, err)
+	}
+
+	return nil
+}
+
+func (c *Client) loadBdSeq() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.bdSeqLoaded || c.Config.BdSeqStore == nil {
+		return nil
+	}
+
+	bdSeq, ok, err := c.Config.BdSeqStore.Load()
+	if err != nil {
+		return err
+	}
+
+	c.bdSeqLoaded = true
+	if ok {
+		c.BdSeq = bdSeq
+		c.bdSeqUsed = true
+	}
+
+	return nil
+}
+
+func (c *Client) nextBdSeq() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.bdSeqUsed {
+		c.BdSeq = (c.BdSeq + 1) % 256
+		c.bdSeqUsed = false
+	}
+
+	if c.Config.BdSeqStore != nil {
+		if err := c.Config.BdSeqStore.Save(c.BdSeq); err != nil {
+			log.Printf("Failed to persist bdSeq %d: %v", c.BdSeq, err)
+		}
+	}
+
+	return c.BdSeq
+}
+
+func (c *Client) currentBdSeq() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.BdSeq
+}
+
+func (c *Client) markBdSeqUsed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bdSeqUsed = true
+}
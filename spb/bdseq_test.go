@@ -0,0 +1,176 @@
+package spb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestFileBdSeqStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    uint64
+		ok      bool
+		wantErr bool
+	}{
+		{name: "missing file"},
+		{name: "stored value", content: "42\n", want: 42, ok: true},
+		{name: "maximum", content: "255", want: 255, ok: true},
+		{name: "out of range", content: "256\n", wantErr: true},
+		{name: "garbage", content: "abc\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "bdseq")
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, ok, err := NewFileBdSeqStore(path).Load()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("Load() = %d, %t, want %d, %t", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestFileBdSeqStoreSave(t *testing.T) {
+	store := NewFileBdSeqStore(filepath.Join(t.TempDir(), "bdseq"))
+	if err := store.Save(17); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	got, ok, err := store.Load()
+	if err != nil || !ok || got != 17 {
+		t.Errorf("Load() = %d, %t, %v, want 17, true, nil", got, ok, err)
+	}
+}
+
+func TestBdSeqSequence(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored string
+		want   []uint64
+	}{
+		{name: "fresh start", want: []uint64{0, 1, 2}},
+		{name: "resumes after restart", stored: "7", want: []uint64{8, 9}},
+		{name: "wraps at 256", stored: "254", want: []uint64{255, 0, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "bdseq")
+			if tt.stored != "" {
+				if err := os.WriteFile(path, []byte(tt.stored), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c := NewClient(Config{GroupID: "g", NodeID: "n", BdSeqStore: NewFileBdSeqStore(path)})
+			if err := c.loadBdSeq(); err != nil {
+				t.Fatalf("loadBdSeq() error = %v", err)
+			}
+
+			for i, want := range tt.want {
+				if got := c.nextBdSeq(); got != want {
+					t.Fatalf("connection %d: bdSeq = %d, want %d", i, got, want)
+				}
+				c.markBdSeqUsed()
+
+				stored, _, err := NewFileBdSeqStore(path).Load()
+				if err != nil || stored != want {
+					t.Fatalf("connection %d: stored bdSeq = %d, %v, want %d", i, stored, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBdSeqUnusedWillIsReused(t *testing.T) {
+	c := NewClient(Config{GroupID: "g", NodeID: "n"})
+
+	if got := c.nextBdSeq(); got != 0 {
+		t.Fatalf("first bdSeq = %d, want 0", got)
+	}
+	if got := c.nextBdSeq(); got != 0 {
+		t.Errorf("bdSeq after a failed connect = %d, want 0", got)
+	}
+}
+
+func TestWillMatchesNBIRTH(t *testing.T) {
+	c := NewClient(Config{GroupID: "g", NodeID: "n"})
+	c.BdSeq = 41
+	c.markBdSeqUsed()
+
+	will, err := c.will()
+	if err != nil {
+		t.Fatalf("will() error = %v", err)
+	}
+
+	var death sproto.Payload
+	if err := proto.Unmarshal(will.Payload, &death); err != nil {
+		t.Fatal(err)
+	}
+	if death.Seq != nil {
+		t.Errorf("NDEATH seq = %d, want none", death.GetSeq())
+	}
+
+	birth, err := c.buildNBIRTHPayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := metricLong(t, &death, "bdSeq"), metricLong(t, birth, "bdSeq"); got != want || got != 42 {
+		t.Errorf("NDEATH bdSeq = %d, NBIRTH bdSeq = %d, want both 42", got, want)
+	}
+}
+
+func TestNBIRTHDuringReconnect(t *testing.T) {
+	c := NewClient(Config{GroupID: "g", NodeID: "n"})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			c.markBdSeqUsed()
+			if _, err := c.will(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		birth, err := c.buildNBIRTHPayload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bdSeq := metricLong(t, birth, "bdSeq"); bdSeq > 100 {
+			t.Fatalf("NBIRTH bdSeq = %d, want at most 100", bdSeq)
+		}
+	}
+	<-done
+}
+
+func metricLong(t *testing.T, payload *sproto.Payload, name string) uint64 {
+	t.Helper()
+
+	for _, metric := range payload.Metrics {
+		if metric.GetName() == name {
+			return metric.GetLongValue()
+		}
+	}
+
+	t.Fatalf("payload has no metric %s", name)
+	return 0
+}
@@ -0,0 +1,80 @@
+package spb
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type Broker struct {
+	Host     string
+	Port     int
+	Scheme   string
+	Path     string
+	Username string
+	Password string
+	TLS      *TLSConfig
+}
+
+func (b Broker) url() (string, error) {
+	return brokerURL(b.Scheme, b.Host, b.Port, b.Path, b.TLS)
+}
+
+func brokerURL(scheme, host string, port int, path string, tlsConfig *TLSConfig) (string, error) {
+	if scheme == "" {
+		scheme = "tcp"
+		if tlsConfig != nil {
+			scheme = "ssl"
+		}
+	}
+
+	switch scheme {
+	case "tcp", "ws":
+		if tlsConfig != nil {
+			return "", fmt.Errorf("TLS configuration requires the ssl or wss scheme, not %s", scheme)
+		}
+	case "ssl", "wss":
+	default:
+		return "", fmt.Errorf("unsupported broker scheme %s", scheme)
+	}
+
+	u := url.URL{
+		Scheme: scheme,
+		Host:   net.JoinHostPort(host, strconv.Itoa(port)),
+	}
+
+	if scheme == "ws" || scheme == "wss" {
+		u.Path = path
+	}
+
+	return u.String(), nil
+}
+
+func configureConnection(opts *mqtt.ClientOptions, username, password string, tlsConfig *TLSConfig) error {
+	if username != "" {
+		opts.SetUsername(username)
+	}
+
+	if password != "" {
+		opts.SetPassword(password)
+	}
+
+	if tlsConfig == nil {
+		return nil
+	}
+
+	config, err := tlsConfig.build()
+	if err != nil {
+		return err
+	}
+	opts.SetTLSConfig(config)
+
+	if tlsConfig.ReloadCerts {
+		opts.SetConnectionAttemptHandler(tlsConfig.onConnectAttempt)
+	}
+
+	return nil
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/tjeumaster/go-sparkplug/sproto"
@@ -18,14 +20,76 @@ type Config struct {
 	ClientID string
 	GroupID  string
 	NodeID   string
+
+	Scheme string
+	Path   string
+	TLS    *TLSConfig
+
+	Brokers []Broker
+
+	PrimaryHostID      string
+	PrimaryHostTimeout time.Duration
+
+	ReportRejectedWrites bool
+
+	Store MessageStore
+
+	BdSeqStore BdSeqStore
+
+	MaxSilence time.Duration
+
+	ScanRate    time.Duration
+	ScanClasses map[string]time.Duration
+
+	Protocol  Protocol
+	MQTT5     MQTT5Options
+	Transport TransportFactory
 }
 
+const (
+	brokerRetryInterval       = 5 * time.Second
+	defaultPrimaryHostTimeout = 30 * time.Second
+)
+
 type Client struct {
 	MqttClient mqtt.Client
 	Config     Config
+	transport  Transport
 	BdSeq      uint64
 	Seq        uint64
 	mu         sync.Mutex
+	seqMu      sync.Mutex
+	born       bool
+
+	bdSeqLoaded bool
+	bdSeqUsed   bool
+
+	brokerIndex int
+	failovers   int
+	stopped     bool
+	hostState   State
+	hostTimer   *time.Timer
+	aliases     *aliasTable
+
+	nodeMetrics   *MetricRegistry
+	deviceMetrics map[string]*MetricRegistry
+	templates     *templateRegistry
+	commands      map[commandKey]CommandHandler
+	rejected      atomic.Uint64
+	replaying     atomic.Bool
+
+	devices     map[string]Device
+	deviceOrder []string
+	bornDevices map[string]bool
+
+	scanRates  map[string]time.Duration
+	scanStop   chan struct{}
+	scanResets map[string]chan struct{}
+
+	heartbeatStop chan struct{}
+
+	handles     map[commandKey]metricHandle
+	handleOrder []commandKey
 }
 
 type Device interface {
@@ -33,54 +97,405 @@ type Device interface {
 	GetMetricValues() map[string]any
 }
 
+type WritableDevice interface {
+	Device
+	SetMetricValue(name string, value any) error
+}
+
 func NewClient(config Config) *Client {
-	return &Client{
-		Config: config,
-		Seq:    0,
-		BdSeq:  0,
+	aliases := newAliasTable()
+	templates := newTemplateRegistry()
+	nodeMetrics := newMetricRegistry("", aliases, templates)
+	nodeMetrics.Declare(MetricDefinition{Name: "bdSeq", DataType: sproto.DataType_Int64})
+	nodeMetrics.Declare(MetricDefinition{Name: "Node Control/Rebirth", DataType: sproto.DataType_Boolean, Writable: true})
+	nodeMetrics.Declare(MetricDefinition{Name: "Node Control/Reboot", DataType: sproto.DataType_Boolean, Writable: true})
+	nodeMetrics.update(map[string]any{
+		"Node Control/Rebirth": false,
+		"Node Control/Reboot":  false,
+	})
+
+	c := &Client{
+		Config:        config,
+		Seq:           0,
+		BdSeq:         0,
+		aliases:       aliases,
+		nodeMetrics:   nodeMetrics,
+		deviceMetrics: make(map[string]*MetricRegistry),
+		templates:     templates,
+		commands:      make(map[commandKey]CommandHandler),
+		devices:       make(map[string]Device),
+		bornDevices:   make(map[string]bool),
+		handles:       make(map[commandKey]metricHandle),
 	}
+
+	c.HandleNodeCommand("Node Control/Rebirth", CommandHandler{Handle: c.onRebirthCommand})
+	c.HandleNodeCommand("Node Control/Reboot", CommandHandler{Handle: c.onRebootCommand})
+
+	return c
+}
+
+func (c *Client) NodeMetrics() *MetricRegistry {
+	return c.nodeMetrics
+}
+
+func (c *Client) DeviceMetrics(deviceID string) *MetricRegistry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	registry, ok := c.deviceMetrics[deviceID]
+	if !ok {
+		registry = newMetricRegistry(deviceID, c.aliases, c.templates)
+		c.deviceMetrics[deviceID] = registry
+	}
+
+	return registry
+}
+
+func (c *Client) DeclareTemplate(name string, definition *Template) error {
+	return c.templates.declare(name, definition)
+}
+
+func (c *Client) NewTemplateInstance(name string) (*Template, error) {
+	return c.templates.instance(name)
+}
+
+func (c *Client) deviceRegistry(deviceID string) (*MetricRegistry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	registry, ok := c.deviceMetrics[deviceID]
+	return registry, ok
 }
 
 func (c *Client) Connect() error {
-	mqttBroker := fmt.Sprintf("tcp://%s:%d", c.Config.Host, c.Config.Port)
+	brokers := c.brokers()
+	for i, broker := range brokers {
+		if _, err := broker.url(); err != nil {
+			return fmt.Errorf("invalid configuration for broker %d: %w", i, err)
+		}
+	}
 
-	ndeathPayload, err := c.buildNDEATHPayload()
-	if err != nil {
-		log.Fatalf("Failed to build NDEATH payload: %v", err)
+	if len(c.Config.ScanClasses) > 0 && c.Config.ScanRate <= 0 {
+		return fmt.Errorf("scan classes require a positive ScanRate")
 	}
 
-	ndeathTopic := fmt.Sprintf("spBv1.0/%s/NDEATH/%s", c.Config.GroupID, c.Config.NodeID)
+	for class, rate := range c.Config.ScanClasses {
+		if rate <= 0 {
+			return fmt.Errorf("scan rate for class %q must be positive", class)
+		}
+	}
 
-	opts := mqtt.NewClientOptions().
-		AddBroker(mqttBroker).
-		SetClientID(c.Config.ClientID).
-		SetWill(ndeathTopic, string(ndeathPayload), 0, true).
-		SetAutoReconnect(true).
-		SetConnectRetry(true).
-		SetOnConnectHandler(func(client mqtt.Client) {
-			if err := c.PublishNBIRTH(); err != nil {
-				log.Printf("Failed to publish NBIRTH on connect: %v", err)
+	if err := c.declareScanRate(); err != nil {
+		return fmt.Errorf("failed to declare scan rate metric: %w", err)
+	}
+
+	if err := c.loadBdSeq(); err != nil {
+		return fmt.Errorf("failed to load bdSeq: %w", err)
+	}
+
+	c.mu.Lock()
+	c.stopped = false
+	c.mu.Unlock()
+
+	c.startScanning()
+	c.startHeartbeat()
+
+	if err := c.reconnect(); err != nil {
+		c.stopScanning()
+		c.stopHeartbeat()
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) brokers() []Broker {
+	if len(c.Config.Brokers) > 0 {
+		return c.Config.Brokers
+	}
+
+	return []Broker{{
+		Host:     c.Config.Host,
+		Port:     c.Config.Port,
+		Scheme:   c.Config.Scheme,
+		Path:     c.Config.Path,
+		Username: c.Config.Username,
+		Password: c.Config.Password,
+		TLS:      c.Config.TLS,
+	}}
+}
+
+func (c *Client) CurrentBroker() Broker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.brokers()[c.brokerIndex]
+}
+
+func (c *Client) advanceBroker() {
+	c.mu.Lock()
+	brokers := len(c.brokers())
+	c.brokerIndex = (c.brokerIndex + 1) % brokers
+	c.hostState = State{}
+	c.failovers++
+	wrapped := c.failovers%brokers == 0
+	c.mu.Unlock()
+
+	if wrapped {
+		log.Printf("Tried all %d MQTT brokers without publishing NBIRTH, retrying in %s", brokers, brokerRetryInterval)
+		time.Sleep(brokerRetryInterval)
+	}
+}
+
+func (c *Client) reconnect() error {
+	brokers := c.brokers()
+	if len(brokers) == 1 {
+		return c.connectBroker(brokers[0], true)
+	}
+
+	for {
+		c.mu.Lock()
+		stopped := c.stopped
+		c.mu.Unlock()
+		if stopped {
+			return fmt.Errorf("client was disconnected during broker failover")
+		}
+
+		broker := c.CurrentBroker()
+		err := c.connectBroker(broker, false)
+		if err == nil {
+			return nil
+		}
+
+		log.Printf("Failed to connect to MQTT broker %s:%d: %v", broker.Host, broker.Port, err)
+		c.advanceBroker()
+	}
+}
+
+func (c *Client) connectBroker(broker Broker, autoReconnect bool) error {
+	mqttBroker, err := broker.url()
+	if err != nil {
+		return fmt.Errorf("invalid broker configuration: %w", err)
+	}
+
+	t, err := c.newTransport(TransportConfig{
+		Broker:        broker,
+		ClientID:      c.Config.ClientID,
+		AutoReconnect: autoReconnect,
+		SessionExpiry: c.Config.MQTT5.SessionExpiry,
+		Will:          c.will,
+		OnConnect:     c.onConnect,
+		OnConnectionLost: func(err error) {
+			log.Printf("Connection to MQTT broker lost: %v", err)
+			c.setBorn(false)
+			if !autoReconnect {
+				go c.onBrokerLost()
 			}
-		})
-	c.MqttClient = mqtt.NewClient(opts)
-	token := c.MqttClient.Connect()
-	token.Wait()
-	if err := token.Error(); err != nil {
-		return fmt.Errorf("failed to connect to MQTT broker: %w", err)
+		},
+	})
+	if err != nil {
+		return err
 	}
 
-	ncmdTopic := fmt.Sprintf("spBv1.0/%s/NCMD/%s", c.Config.GroupID, c.Config.NodeID)
-	dcmdTopic := fmt.Sprintf("spBv1.0/%s/DCMD/%s/+", c.Config.GroupID, c.Config.NodeID)
-	c.MqttClient.Subscribe(ncmdTopic, 0, c.onCommandReceived)
-	c.MqttClient.Subscribe(dcmdTopic, 0, c.onCommandReceived)
+	c.mu.Lock()
+	c.transport = t
+	c.MqttClient = nil
+	if v3, ok := t.(*pahoTransport); ok {
+		c.MqttClient = v3.client
+	}
+	c.mu.Unlock()
+
+	if err := t.Connect(); err != nil {
+		return err
+	}
 
 	log.Printf("Connected to MQTT broker at %s", mqttBroker)
 
 	return nil
 }
 
+func (c *Client) newTransport(config TransportConfig) (Transport, error) {
+	if c.Config.Transport != nil {
+		return c.Config.Transport(config)
+	}
+
+	return newTransport(c.Config.Protocol, config)
+}
+
+func (c *Client) onBrokerLost() {
+	c.advanceBroker()
+	if err := c.reconnect(); err != nil {
+		log.Printf("Failed to fail over to the next MQTT broker: %v", err)
+	}
+}
+
+func (c *Client) will() (*WillMessage, error) {
+	bdSeq := c.nextBdSeq()
+
+	payload, err := c.buildNDEATHPayload()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build NDEATH payload: %w", err)
+	}
+
+	ndeathPayload, err := proto.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal NDEATH payload: %w", err)
+	}
+
+	log.Printf("Registered NDEATH will with bdSeq %d", bdSeq)
+
+	return &WillMessage{
+		Topic:    nodeTopic(c.Config.GroupID, MessageTypeNDEATH, c.Config.NodeID),
+		Payload:  ndeathPayload,
+		QoS:      0,
+		Retained: true,
+	}, nil
+}
+
+func (c *Client) onConnect() {
+	c.markBdSeqUsed()
+
+	t := c.currentTransport()
+	if t == nil {
+		return
+	}
+
+	ncmdTopic := nodeTopic(c.Config.GroupID, MessageTypeNCMD, c.Config.NodeID)
+	dcmdTopic := deviceTopic(c.Config.GroupID, MessageTypeDCMD, c.Config.NodeID, "+")
+	for _, topic := range []string{ncmdTopic, dcmdTopic} {
+		if err := t.Subscribe(topic, 0, c.onCommandReceived); err != nil {
+			log.Printf("Failed to subscribe to %s: %v", topic, err)
+		}
+	}
+
+	if c.Config.PrimaryHostID != "" {
+		topic := stateTopic(c.Config.PrimaryHostID)
+		if err := t.Subscribe(topic, 1, c.onStateReceived); err != nil {
+			log.Printf("Failed to subscribe to %s: %v", topic, err)
+		}
+
+		log.Printf("Waiting for primary host %s to come online before publishing NBIRTH", c.Config.PrimaryHostID)
+		c.watchPrimaryHost(t)
+		return
+	}
+
+	if err := c.PublishNBIRTH(); err != nil {
+		log.Printf("Failed to publish NBIRTH on connect: %v", err)
+	}
+}
+
+func (c *Client) onStateReceived(msg TransportMessage) {
+	state, err := parseStatePayload(msg.Payload)
+	if err != nil {
+		log.Printf("Failed to decode STATE payload on topic %s: %v", msg.Topic, err)
+		return
+	}
+
+	c.mu.Lock()
+	if state.Timestamp < c.hostState.Timestamp {
+		c.mu.Unlock()
+		log.Printf("Ignoring stale STATE for primary host %s", c.Config.PrimaryHostID)
+		return
+	}
+	c.hostState = state
+	born := c.born
+	c.mu.Unlock()
+
+	switch {
+	case state.Online && !born:
+		log.Printf("Primary host %s is online", c.Config.PrimaryHostID)
+		go func() {
+			if err := c.PublishNBIRTH(); err != nil {
+				log.Printf("Failed to publish NBIRTH after primary host came online: %v", err)
+			}
+		}()
+
+	case !state.Online && (born || len(c.brokers()) > 1):
+		log.Printf("Primary host %s is offline", c.Config.PrimaryHostID)
+		go c.onPrimaryHostOffline()
+	}
+}
+
+func (c *Client) watchPrimaryHost(t Transport) {
+	if len(c.brokers()) < 2 {
+		return
+	}
+
+	timeout := c.Config.PrimaryHostTimeout
+	if timeout <= 0 {
+		timeout = defaultPrimaryHostTimeout
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.hostTimer != nil {
+		c.hostTimer.Stop()
+	}
+	c.hostTimer = time.AfterFunc(timeout, func() {
+		c.mu.Lock()
+		waiting := c.transport == t && !c.stopped && !c.hostState.Online
+		c.mu.Unlock()
+		if !waiting {
+			return
+		}
+
+		log.Printf("Primary host %s did not come online within %s", c.Config.PrimaryHostID, timeout)
+		c.onPrimaryHostOffline()
+	})
+}
+
+func (c *Client) stopWatchingPrimaryHost() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.hostTimer != nil {
+		c.hostTimer.Stop()
+		c.hostTimer = nil
+	}
+}
+
+func (c *Client) onPrimaryHostOffline() {
+	c.mu.Lock()
+	born := c.born
+	c.mu.Unlock()
+
+	if born {
+		if err := c.PublishNDEATH(); err != nil {
+			log.Printf("Failed to publish NDEATH after primary host went offline: %v", err)
+		}
+	}
+
+	if t := c.currentTransport(); t != nil {
+		t.Disconnect()
+	}
+	log.Printf("Disconnected from MQTT broker while primary host %s is offline", c.Config.PrimaryHostID)
+
+	if len(c.brokers()) > 1 {
+		c.advanceBroker()
+	}
+
+	if err := c.reconnect(); err != nil {
+		log.Printf("Failed to reconnect to MQTT broker: %v", err)
+	}
+}
+
+func (c *Client) IsPrimaryHostOnline() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hostState.Online
+}
+
 func (c *Client) Disconnect() error {
-	if c.MqttClient == nil || !c.MqttClient.IsConnected() {
+	c.mu.Lock()
+	c.stopped = true
+	c.mu.Unlock()
+
+	c.stopScanning()
+	c.stopHeartbeat()
+	c.stopWatchingPrimaryHost()
+
+	t := c.currentTransport()
+	if t == nil || !t.IsConnected() {
 		return fmt.Errorf("MQTT client is not connected")
 	}
 
@@ -88,47 +503,118 @@ func (c *Client) Disconnect() error {
 		log.Printf("Failed to publish NDEATH before disconnect: %v", err)
 	}
 
-	c.MqttClient.Disconnect(250)
+	t.Disconnect()
 
 	log.Printf("Disconnected from MQTT broker")
+	c.mu.Lock()
+	c.transport = nil
 	c.MqttClient = nil
+	c.mu.Unlock()
+	c.seqMu.Lock()
 	c.Seq = 0
+	c.seqMu.Unlock()
 	log.Printf("MQTT client stopped and reset")
 	return nil
 }
 
-func (c *Client) publish(topic string, payload []byte, retained bool) error {
-	token := c.MqttClient.Publish(topic, 0, retained, payload)
-	token.Wait()
-	if err := token.Error(); err != nil {
+func (c *Client) currentTransport() Transport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.transport
+}
+
+func (c *Client) isConnected() bool {
+	t := c.currentTransport()
+	return t != nil && t.IsConnected()
+}
+
+func (c *Client) publish(messageType, topic string, payload *sproto.Payload, retained bool, userProperties map[string]string) error {
+	t := c.currentTransport()
+	if t == nil {
+		return fmt.Errorf("MQTT client is not connected")
+	}
+
+	c.seqMu.Lock()
+	defer c.seqMu.Unlock()
+
+	seq := c.Seq
+	switch messageType {
+	case MessageTypeNBIRTH:
+		seq = 0
+		payload.Seq = proto.Uint64(seq)
+	case MessageTypeNDEATH:
+		payload.Seq = nil
+	default:
+		payload.Seq = proto.Uint64(seq)
+	}
+
+	payloadBytes, err := proto.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s payload: %w", messageType, err)
+	}
+
+	if err := t.Publish(topic, 0, retained, payloadBytes, c.publishOptions(messageType, userProperties)); err != nil {
 		return fmt.Errorf("failed to publish to topic %s: %w", topic, err)
 	}
 
-	c.incrementSeq()
+	if messageType != MessageTypeNDEATH {
+		c.Seq = (seq + 1) % 256
+	}
 
 	return nil
 }
 
-func (c *Client) incrementSeq() {
+func (c *Client) publishOptions(messageType string, userProperties map[string]string) PublishOptions {
+	options := PublishOptions{}
+
+	if messageType == MessageTypeNDATA || messageType == MessageTypeDDATA {
+		options.MessageExpiry = c.Config.MQTT5.MessageExpiry
+	}
+
+	if len(c.Config.MQTT5.UserProperties) > 0 || len(userProperties) > 0 {
+		options.UserProperties = make(map[string]string, len(c.Config.MQTT5.UserProperties)+len(userProperties))
+		for key, value := range c.Config.MQTT5.UserProperties {
+			options.UserProperties[key] = value
+		}
+		for key, value := range userProperties {
+			options.UserProperties[key] = value
+		}
+	}
+
+	return options
+}
+
+func (c *Client) setBorn(born bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.Seq = (c.Seq + 1) % 256
+	c.born = born
+	c.bornDevices = make(map[string]bool)
+	if born {
+		c.failovers = 0
+	}
 }
 
 func (c *Client) PublishNBIRTH() error {
-	c.BdSeq++
+	dirty := c.dirtyHandles("")
 	payload, err := c.buildNBIRTHPayload()
 	if err != nil {
 		return fmt.Errorf("failed to build NBIRTH payload: %w", err)
 	}
 
-	topic := fmt.Sprintf("spBv1.0/%s/NBIRTH/%s", c.Config.GroupID, c.Config.NodeID)
-	if err := c.publish(topic, payload, true); err != nil {
+	topic := nodeTopic(c.Config.GroupID, MessageTypeNBIRTH, c.Config.NodeID)
+	if err := c.publish(MessageTypeNBIRTH, topic, payload, true, nil); err != nil {
 		return fmt.Errorf("failed to publish NBIRTH: %w", err)
 	}
 
+	markPublished(dirty)
+	c.setBorn(true)
+	c.resetReported()
+
 	log.Printf("Published NBIRTH to topic %s", topic)
 
+	c.rebirthDevices()
+	c.replayStored()
+
 	return nil
 }
 
@@ -137,27 +623,34 @@ func (c *Client) PublishNDEATH() error {
 	if err != nil {
 		return fmt.Errorf("failed to build NDEATH payload: %w", err)
 	}
-	topic := fmt.Sprintf("spBv1.0/%s/NDEATH/%s", c.Config.GroupID, c.Config.NodeID)
-	if err := c.publish(topic, payload, true); err != nil {
+	topic := nodeTopic(c.Config.GroupID, MessageTypeNDEATH, c.Config.NodeID)
+	if err := c.publish(MessageTypeNDEATH, topic, payload, true, nil); err != nil {
 		return fmt.Errorf("failed to publish NDEATH: %w", err)
 	}
 
+	c.setBorn(false)
+
 	log.Printf("Published NDEATH to topic %s", topic)
 
 	return nil
 }
 
 func (c *Client) PublishDBIRTH(device Device) error {
+	dirty := c.dirtyHandles(device.GetId())
 	payload, err := c.buildDBIRTHPayload(device)
 	if err != nil {
 		return fmt.Errorf("failed to build DBIRTH payload: %w", err)
 	}
 
-	topic := fmt.Sprintf("spBv1.0/%s/DBIRTH/%s/%s", c.Config.GroupID, c.Config.NodeID, device.GetId())
-	if err := c.publish(topic, payload, false); err != nil {
+	topic := deviceTopic(c.Config.GroupID, MessageTypeDBIRTH, c.Config.NodeID, device.GetId())
+	if err := c.publish(MessageTypeDBIRTH, topic, payload, false, nil); err != nil {
 		return fmt.Errorf("failed to publish DBIRTH: %w", err)
 	}
 
+	markPublished(dirty)
+	c.setDeviceBorn(device, true)
+	c.DeviceMetrics(device.GetId()).resetReported()
+
 	log.Printf("Published DBIRTH for device %s to topic %s", device.GetId(), topic)
 
 	return nil
@@ -169,24 +662,48 @@ func (c *Client) PublishDDEATH(device Device) error {
 		return fmt.Errorf("failed to build DDEATH payload: %w", err)
 	}
 
-	topic := fmt.Sprintf("spBv1.0/%s/DDEATH/%s/%s", c.Config.GroupID, c.Config.NodeID, device.GetId())
-	if err := c.publish(topic, payload, false); err != nil {
+	topic := deviceTopic(c.Config.GroupID, MessageTypeDDEATH, c.Config.NodeID, device.GetId())
+	if err := c.publish(MessageTypeDDEATH, topic, payload, false, nil); err != nil {
 		return fmt.Errorf("failed to publish DDEATH: %w", err)
 	}
 
+	c.setDeviceBorn(device, false)
+
 	log.Printf("Published DDEATH for device %s to topic %s", device.GetId(), topic)
 
 	return nil
 }
 
 func (c *Client) PublishNDATA(metricValues map[string]any) error {
+	return c.publishNDATA(orderedValues(metricValues), nil)
+}
+
+func (c *Client) publishNDATA(metricValues []metricValue, userProperties map[string]string) error {
+	store := c.shouldStore()
+	if !store {
+		if err := c.checkNodeBorn(); err != nil {
+			return fmt.Errorf("failed to publish NDATA: %w", err)
+		}
+		if err := c.publishPendingBirths(""); err != nil {
+			return fmt.Errorf("failed to publish NDATA: %w", err)
+		}
+	}
+
 	payload, err := c.buildNDATAPayload(metricValues)
 	if err != nil {
 		return fmt.Errorf("failed to build NDATA payload: %w", err)
 	}
 
-	topic := fmt.Sprintf("spBv1.0/%s/NDATA/%s", c.Config.GroupID, c.Config.NodeID)
-	if err := c.publish(topic, payload, false); err != nil {
+	topic := nodeTopic(c.Config.GroupID, MessageTypeNDATA, c.Config.NodeID)
+	if store {
+		return c.storeMessage(topic, payload)
+	}
+
+	if err := c.publish(MessageTypeNDATA, topic, payload, false, userProperties); err != nil {
+		if c.Config.Store != nil {
+			log.Printf("Failed to publish NDATA, storing it for later: %v", err)
+			return c.storeMessage(topic, payload)
+		}
 		return fmt.Errorf("failed to publish NDATA: %w", err)
 	}
 
@@ -196,13 +713,39 @@ func (c *Client) PublishNDATA(metricValues map[string]any) error {
 }
 
 func (c *Client) PublishDDATA(device Device, metricValues map[string]any) error {
-	payload, err := c.buildDDATAPayload(metricValues)
+	return c.publishDDATA(device, orderedValues(metricValues), nil)
+}
+
+func (c *Client) publishDDATA(device Device, metricValues []metricValue, userProperties map[string]string) error {
+	store := c.shouldStore()
+	if store {
+		c.mu.Lock()
+		_, ok := c.devices[device.GetId()]
+		c.mu.Unlock()
+		if !ok {
+			return fmt.Errorf("failed to store DDATA: device %s is not registered", device.GetId())
+		}
+	} else if err := c.checkDeviceBorn(device.GetId()); err != nil {
+		return fmt.Errorf("failed to publish DDATA: %w", err)
+	} else if err := c.publishPendingBirths(device.GetId()); err != nil {
+		return fmt.Errorf("failed to publish DDATA: %w", err)
+	}
+
+	payload, err := c.buildDDATAPayload(device.GetId(), metricValues)
 	if err != nil {
 		return fmt.Errorf("failed to build DDATA payload: %w", err)
 	}
 
-	topic := fmt.Sprintf("spBv1.0/%s/DDATA/%s/%s", c.Config.GroupID, c.Config.NodeID, device.GetId())
-	if err := c.publish(topic, payload, false); err != nil {
+	topic := deviceTopic(c.Config.GroupID, MessageTypeDDATA, c.Config.NodeID, device.GetId())
+	if store {
+		return c.storeMessage(topic, payload)
+	}
+
+	if err := c.publish(MessageTypeDDATA, topic, payload, false, userProperties); err != nil {
+		if c.Config.Store != nil {
+			log.Printf("Failed to publish DDATA, storing it for later: %v", err)
+			return c.storeMessage(topic, payload)
+		}
 		return fmt.Errorf("failed to publish DDATA: %w", err)
 	}
 
@@ -211,43 +754,51 @@ func (c *Client) PublishDDATA(device Device, metricValues map[string]any) error
 	return nil
 }
 
-func (c *Client) onCommandReceived(client mqtt.Client, msg mqtt.Message) {
-	payloadBytes := msg.Payload()
+func (c *Client) publishPendingBirths(deviceID string) error {
+	c.mu.Lock()
+	born := c.born
+	device, registered := c.devices[deviceID]
+	registry := c.deviceMetrics[deviceID]
+	c.mu.Unlock()
+	if !born {
+		return nil
+	}
+
+	if c.nodeMetrics.needsRebirth() || c.templates.needsRebirth() {
+		log.Printf("Node metrics or templates were declared after NBIRTH, publishing NBIRTH")
+		return c.PublishNBIRTH()
+	}
 
-	var payload sproto.Payload
-	err := proto.Unmarshal(payloadBytes, &payload)
+	if deviceID == "" || !registered || registry == nil || !registry.needsRebirth() || !c.IsDeviceBorn(deviceID) {
+		return nil
+	}
+
+	log.Printf("Metrics of device %s were declared after DBIRTH, publishing DBIRTH", deviceID)
+
+	return c.PublishDBIRTH(device)
+}
+
+func (c *Client) onCommandReceived(msg TransportMessage) {
+	payload, err := unmarshalPayload(msg.Payload)
 	if err != nil {
 		log.Printf("Failed to decode command payload: %v", err)
 		return
 	}
 
-	for _, metric := range payload.Metrics {
-		if err := c.handleCommandMetric(metric, msg.Topic()); err != nil {
-			log.Printf("Error handling command metric: %v", err)
-		}
+	topic, err := ParseTopic(msg.Topic)
+	if err != nil {
+		log.Printf("Failed to parse command topic: %v", err)
+		return
 	}
-}
-
-func (c *Client) handleCommandMetric(metric *sproto.Payload_Metric, topic string) error {
-	name := metric.GetName()
 
-	switch name {
-	case "Node Control/Rebirth":
-		log.Printf("Received Rebirth command on topic %s", topic)
-		err := c.PublishNBIRTH()
-		if err != nil {
-			return err
+	for _, metric := range payload.Metrics {
+		if !c.aliases.resolveMetric(topic.DeviceID, metric) {
+			log.Printf("Received command with unknown alias %d on topic %s", metric.GetAlias(), msg.Topic)
+			continue
 		}
 
-		log.Printf("Published NBIRTH in response to Rebirth command on topic %s", topic)
-		return nil
-
-	case "Node Control/Reboot":
-		log.Printf("Received Reboot command on topic %s", topic)
-		return nil
-
-	default:
-		log.Printf("Received unknown command '%s' on topic %s", name, topic)
-		return nil
+		if err := c.handleCommandMetric(topic, metric, msg.UserProperties); err != nil {
+			log.Printf("Error handling command metric: %v", err)
+		}
 	}
 }
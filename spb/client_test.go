@@ -0,0 +1,148 @@
+package spb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tjeumaster/go-sparkplug/spbtest"
+	"github.com/tjeumaster/go-sparkplug/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestClientLifecycle(t *testing.T) {
+	b, err := spbtest.NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+
+	_, events := newTestHost(t, HostConfig{Host: b.Host(), Port: b.Port(), ClientID: "host", HostID: "monitor"}, b.Retained)
+
+	c := NewClient(Config{Host: b.Host(), Port: b.Port(), ClientID: "edge", GroupID: "plant", NodeID: "edge-1"})
+	connectTestClient(t, c)
+
+	birth := waitForEvent(t, events, MessageTypeNBIRTH)
+	if birth.payload.Seq == nil || birth.payload.GetSeq() != 0 {
+		t.Errorf("NBIRTH seq = %v, want 0", birth.payload.Seq)
+	}
+	bdSeq := metricLong(t, birth.payload, "bdSeq")
+
+	pump := &testDevice{id: "pump", values: map[string]any{"speed": 1200.0}}
+	if err := c.AddDevice(pump); err != nil {
+		t.Fatalf("AddDevice() error = %v", err)
+	}
+
+	dbirth := waitForEvent(t, events, MessageTypeDBIRTH)
+	if dbirth.deviceID != "pump" || dbirth.payload.GetSeq() != 1 {
+		t.Errorf("DBIRTH for %q seq = %d, want pump seq 1", dbirth.deviceID, dbirth.payload.GetSeq())
+	}
+	if _, ok := findMetric(dbirth.payload, "speed"); !ok {
+		t.Errorf("DBIRTH metrics = %v, want speed", dbirth.payload.Metrics)
+	}
+
+	if err := c.PublishDDATA(pump, map[string]any{"speed": 1250.0}); err != nil {
+		t.Fatalf("PublishDDATA() error = %v", err)
+	}
+
+	ddata := waitForEvent(t, events, MessageTypeDDATA)
+	if metric, ok := findMetric(ddata.payload, "speed"); !ok || metric.GetDoubleValue() != 1250 || ddata.payload.GetSeq() != 2 {
+		t.Errorf("DDATA seq %d = %v, want seq 2 speed 1250", ddata.payload.GetSeq(), ddata.payload.Metrics)
+	}
+
+	publishRebirth(t, b)
+
+	rebirth := waitForEvent(t, events, MessageTypeNBIRTH)
+	if rebirth.payload.GetSeq() != 0 {
+		t.Errorf("rebirth NBIRTH seq = %d, want 0", rebirth.payload.GetSeq())
+	}
+	if got := metricLong(t, rebirth.payload, "bdSeq"); got != bdSeq {
+		t.Errorf("rebirth NBIRTH bdSeq = %d, want %d", got, bdSeq)
+	}
+	waitForEvent(t, events, MessageTypeDBIRTH)
+
+	if !b.DropClient("edge") {
+		t.Fatal("DropClient() = false, want true")
+	}
+
+	death := waitForEvent(t, events, MessageTypeNDEATH)
+	if death.payload.Seq != nil {
+		t.Errorf("NDEATH seq = %d, want none", death.payload.GetSeq())
+	}
+	if got := metricLong(t, death.payload, "bdSeq"); got != bdSeq {
+		t.Errorf("NDEATH bdSeq = %d, want the NBIRTH bdSeq %d", got, bdSeq)
+	}
+	if _, ok := b.Retained("spBv1.0/plant/NDEATH/edge-1"); !ok {
+		t.Error("NDEATH will was not retained")
+	}
+
+	reconnected := waitForEvent(t, events, MessageTypeNBIRTH)
+	if got := metricLong(t, reconnected.payload, "bdSeq"); got != bdSeq+1 {
+		t.Errorf("NBIRTH bdSeq after reconnect = %d, want %d", got, bdSeq+1)
+	}
+}
+
+func TestClientBrokerFailover(t *testing.T) {
+	brokers := make([]*spbtest.Broker, 2)
+	events := make([]<-chan hostEvent, 2)
+	for i := range brokers {
+		b, err := spbtest.NewBroker()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { b.Close() })
+
+		brokers[i] = b
+		_, events[i] = newTestHost(t, HostConfig{Host: b.Host(), Port: b.Port(), ClientID: "host", HostID: "monitor"}, b.Retained)
+	}
+
+	c := NewClient(Config{
+		Brokers: []Broker{
+			{Host: brokers[0].Host(), Port: brokers[0].Port()},
+			{Host: brokers[1].Host(), Port: brokers[1].Port()},
+		},
+		ClientID: "edge",
+		GroupID:  "plant",
+		NodeID:   "edge-1",
+	})
+	connectTestClient(t, c)
+
+	birth := waitForEvent(t, events[0], MessageTypeNBIRTH)
+	bdSeq := metricLong(t, birth.payload, "bdSeq")
+
+	if !brokers[0].DropClient("edge") {
+		t.Fatal("DropClient() = false, want true")
+	}
+
+	death := waitForEvent(t, events[0], MessageTypeNDEATH)
+	if got := metricLong(t, death.payload, "bdSeq"); got != bdSeq {
+		t.Errorf("NDEATH bdSeq on broker A = %d, want the NBIRTH bdSeq %d", got, bdSeq)
+	}
+
+	failover := waitForEvent(t, events[1], MessageTypeNBIRTH)
+	if got := metricLong(t, failover.payload, "bdSeq"); got != bdSeq+1 {
+		t.Errorf("NBIRTH bdSeq on broker B = %d, want %d", got, bdSeq+1)
+	}
+	if failover.payload.GetSeq() != 0 {
+		t.Errorf("NBIRTH seq on broker B = %d, want 0", failover.payload.GetSeq())
+	}
+
+	if broker := c.CurrentBroker(); broker.Port != brokers[1].Port() {
+		t.Errorf("CurrentBroker() port = %d, want broker B on %d", broker.Port, brokers[1].Port())
+	}
+	if !brokers[1].Connected("edge") || brokers[0].Connected("edge") {
+		t.Errorf("edge connected to A %t, B %t, want only B", brokers[0].Connected("edge"), brokers[1].Connected("edge"))
+	}
+	expectNoEvent(t, events[0], MessageTypeNBIRTH, 50*time.Millisecond)
+}
+
+func publishRebirth(t *testing.T, b *spbtest.Broker) {
+	t.Helper()
+
+	metric := commandMetric(t, "Node Control/Rebirth", sproto.DataType_Boolean, true)
+	data, err := proto.Marshal(&sproto.Payload{Metrics: []*sproto.Payload_Metric{metric}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Publish("spBv1.0/plant/NCMD/edge-1", data, false)
+}
@@ -0,0 +1,269 @@
+package spb
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+type Command struct {
+	DeviceID string
+	Name     string
+	Value    any
+	Metric   *sproto.Payload_Metric
+
+	UserProperties map[string]string
+}
+
+type WriteRejectedError struct {
+	DeviceID string
+	Name     string
+	Reason   string
+}
+
+func (e *WriteRejectedError) Error() string {
+	if e.DeviceID == "" {
+		return fmt.Sprintf("write to metric %s rejected: %s", e.Name, e.Reason)
+	}
+
+	return fmt.Sprintf("write to metric %s on device %s rejected: %s", e.Name, e.DeviceID, e.Reason)
+}
+
+type CommandFunc func(cmd Command) error
+
+type CommandHandler struct {
+	Handle CommandFunc
+	Echo   bool
+}
+
+type commandKey struct {
+	deviceID string
+	name     string
+}
+
+func (c *Client) HandleNodeCommand(name string, handler CommandHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.commands[commandKey{name: name}] = handler
+}
+
+func (c *Client) HandleDeviceCommand(deviceID, name string, handler CommandHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.commands[commandKey{deviceID: deviceID, name: name}] = handler
+}
+
+func (c *Client) RemoveNodeCommand(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.commands, commandKey{name: name})
+}
+
+func (c *Client) RemoveDeviceCommand(deviceID, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.commands, commandKey{deviceID: deviceID, name: name})
+}
+
+func (c *Client) handleCommandMetric(topic Topic, metric *sproto.Payload_Metric, userProperties map[string]string) error {
+	name := metric.GetName()
+
+	c.mu.Lock()
+	handler, ok := c.commands[commandKey{deviceID: topic.DeviceID, name: name}]
+	c.mu.Unlock()
+	handled := ok && handler.Handle != nil
+
+	definition, declared := c.metricDefinition(topic.DeviceID, name)
+	if !declared && !handled {
+		log.Printf("Received unknown command '%s' on topic %s", name, topic)
+		return nil
+	}
+
+	if declared && metric.Datatype == nil {
+		metric.Datatype = proto.Uint32(uint32(definition.DataType))
+	}
+
+	value, err := MetricValue(metric)
+	if err != nil {
+		return fmt.Errorf("failed to decode command %s on topic %s: %w", name, topic, err)
+	}
+
+	cmd := Command{
+		DeviceID: topic.DeviceID,
+		Name:     name,
+		Value:    value,
+		Metric:   metric,
+
+		UserProperties: userProperties,
+	}
+
+	if declared {
+		if !definition.Writable && (!handled || !c.metricInferred(topic.DeviceID, name)) {
+			return c.rejectWrite(cmd, "metric is read-only")
+		}
+
+		if _, ok := encodeValue(definition.DataType, value); !ok {
+			return c.rejectWrite(cmd, fmt.Sprintf("value of type %T is not valid for datatype %s", value, definition.DataType))
+		}
+	}
+
+	if !handled {
+		return c.writeMetric(cmd)
+	}
+
+	if err := handler.Handle(cmd); err != nil {
+		return fmt.Errorf("command %s on topic %s failed: %w", name, topic, err)
+	}
+
+	if handler.Echo {
+		return c.echoCommand(cmd)
+	}
+
+	return nil
+}
+
+func (c *Client) metricDefinition(deviceID, name string) (MetricDefinition, bool) {
+	registry, ok := c.metricRegistry(deviceID)
+	if !ok {
+		return MetricDefinition{}, false
+	}
+
+	return registry.Definition(name)
+}
+
+func (c *Client) metricInferred(deviceID, name string) bool {
+	registry, ok := c.metricRegistry(deviceID)
+	return ok && registry.isInferred(name)
+}
+
+func (c *Client) metricRegistry(deviceID string) (*MetricRegistry, bool) {
+	if deviceID == "" {
+		return c.nodeMetrics, true
+	}
+
+	return c.deviceRegistry(deviceID)
+}
+
+func (c *Client) echoCommand(cmd Command) error {
+	metricValues := []metricValue{{name: cmd.Name, value: cmd.Value}}
+
+	if cmd.DeviceID == "" {
+		return c.publishNDATA(metricValues, cmd.UserProperties)
+	}
+
+	c.mu.Lock()
+	device, ok := c.devices[cmd.DeviceID]
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("cannot echo command %s for unregistered device %s", cmd.Name, cmd.DeviceID)
+	}
+
+	return c.publishDDATA(device, metricValues, cmd.UserProperties)
+}
+
+func (c *Client) writeMetric(cmd Command) error {
+	if h, ok := c.handleFor(cmd.DeviceID, cmd.Name); ok {
+		version, err := h.apply(cmd.Value)
+		if err != nil {
+			return c.rejectWrite(cmd, err.Error())
+		}
+
+		log.Printf("Applied write to metric handle %s", cmd.Name)
+
+		if err := c.echoCommand(cmd); err != nil {
+			return err
+		}
+		h.published(version)
+
+		return nil
+	}
+
+	if cmd.DeviceID == "" {
+		return c.rejectWrite(cmd, "no command handler or metric handle is registered")
+	}
+
+	c.mu.Lock()
+	device, ok := c.devices[cmd.DeviceID]
+	c.mu.Unlock()
+	if !ok {
+		return c.rejectWrite(cmd, "device is not registered")
+	}
+
+	writable, ok := device.(WritableDevice)
+	if !ok {
+		return c.rejectWrite(cmd, "device does not accept writes")
+	}
+
+	if err := writable.SetMetricValue(cmd.Name, cmd.Value); err != nil {
+		return c.rejectWrite(cmd, err.Error())
+	}
+
+	log.Printf("Applied write to metric %s on device %s", cmd.Name, cmd.DeviceID)
+
+	if value, ok := device.GetMetricValues()[cmd.Name]; ok {
+		cmd.Value = value
+	}
+
+	return c.echoCommand(cmd)
+}
+
+func (c *Client) rejectWrite(cmd Command, reason string) error {
+	c.rejected.Add(1)
+	err := &WriteRejectedError{DeviceID: cmd.DeviceID, Name: cmd.Name, Reason: reason}
+
+	if c.Config.ReportRejectedWrites {
+		if reportErr := c.reportRejectedWrite(cmd, reason); reportErr != nil {
+			log.Printf("Failed to report rejected write to metric %s: %v", cmd.Name, reportErr)
+		}
+	}
+
+	return err
+}
+
+func (c *Client) reportRejectedWrite(cmd Command, reason string) error {
+	registry := c.nodeMetrics
+	if cmd.DeviceID != "" {
+		var ok bool
+		registry, ok = c.deviceRegistry(cmd.DeviceID)
+		if !ok {
+			return fmt.Errorf("no metrics declared for device %s", cmd.DeviceID)
+		}
+	}
+
+	value, ok := registry.Value(cmd.Name)
+	if !ok {
+		return fmt.Errorf("metric %s has no current value", cmd.Name)
+	}
+
+	update := MetricUpdate{
+		Value:      value,
+		Properties: PropertySet{PropertyWriteError: {DataType: sproto.DataType_String, Value: reason}},
+	}
+
+	return c.echoCommand(Command{DeviceID: cmd.DeviceID, Name: cmd.Name, Value: update, UserProperties: cmd.UserProperties})
+}
+
+func (c *Client) RejectedWrites() uint64 {
+	return c.rejected.Load()
+}
+
+func (c *Client) onRebirthCommand(cmd Command) error {
+	if rebirth, ok := cmd.Value.(bool); ok && !rebirth {
+		return nil
+	}
+
+	log.Printf("Received Rebirth command")
+	if err := c.PublishNBIRTH(); err != nil {
+		return err
+	}
+
+	log.Printf("Published NBIRTH in response to Rebirth command")
+	return nil
+}
+
+func (c *Client) onRebootCommand(cmd Command) error {
+	log.Printf("Received Reboot command")
+	return nil
+}
@@ -0,0 +1,147 @@
+package spb
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+)
+
+func TestCommandWritableAccess(t *testing.T) {
+	tests := []struct {
+		name     string
+		deviceID string
+		writable bool
+		inferred bool
+		handled  bool
+		accepted bool
+	}{
+		{name: "writable node metric", writable: true, handled: true, accepted: true},
+		{name: "read-only node metric", handled: true},
+		{name: "writable device metric", deviceID: "pump", writable: true, handled: true, accepted: true},
+		{name: "read-only device metric", deviceID: "pump", handled: true},
+		{name: "inferred device metric with handler", deviceID: "pump", inferred: true, handled: true, accepted: true},
+		{name: "inferred device metric without handler", deviceID: "pump", inferred: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loop := NewLoopback()
+			_, events := newLoopbackHost(t, loop)
+			c := newTestClient(t, loop, Config{})
+
+			registry, topic, dataType := c.NodeMetrics(), "spBv1.0/plant/NCMD/edge-1", MessageTypeNDATA
+			if tt.deviceID != "" {
+				registry, topic, dataType = c.DeviceMetrics(tt.deviceID), "spBv1.0/plant/DCMD/edge-1/"+tt.deviceID, MessageTypeDDATA
+			}
+			if !tt.inferred {
+				if _, err := registry.Declare(MetricDefinition{Name: "Setpoint", DataType: sproto.DataType_Double, Writable: tt.writable}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			commands := make(chan Command, 1)
+			handler := CommandHandler{
+				Echo: true,
+				Handle: func(cmd Command) error {
+					commands <- cmd
+					return nil
+				},
+			}
+			switch {
+			case !tt.handled:
+			case tt.deviceID == "":
+				c.HandleNodeCommand("Setpoint", handler)
+			default:
+				c.HandleDeviceCommand(tt.deviceID, "Setpoint", handler)
+			}
+
+			connectTestClient(t, c)
+			waitForEvent(t, events, MessageTypeNBIRTH)
+			if tt.deviceID != "" {
+				if err := c.AddDevice(&testDevice{id: tt.deviceID, values: map[string]any{"Setpoint": 1.0}}); err != nil {
+					t.Fatal(err)
+				}
+				waitForEvent(t, events, MessageTypeDBIRTH)
+			}
+
+			publishCommand(t, loop, topic, commandMetric(t, "Setpoint", sproto.DataType_Double, 42.5))
+
+			if !tt.accepted {
+				waitUntil(t, "the write is rejected", func() bool { return c.RejectedWrites() == 1 })
+				select {
+				case cmd := <-commands:
+					t.Errorf("handler called with %+v for a read-only metric", cmd)
+				default:
+				}
+				expectNoEvent(t, events, dataType, 50*time.Millisecond)
+				return
+			}
+
+			select {
+			case cmd := <-commands:
+				if cmd.DeviceID != tt.deviceID || cmd.Value != 42.5 {
+					t.Errorf("command = %+v, want %q Setpoint 42.5", cmd, tt.deviceID)
+				}
+			case <-time.After(loopbackTimeout):
+				t.Fatal("timed out waiting for the command handler")
+			}
+
+			echo := waitForEvent(t, events, dataType)
+			if metric, ok := findMetric(echo.payload, "Setpoint"); echo.deviceID != tt.deviceID || !ok || metric.GetDoubleValue() != 42.5 {
+				t.Errorf("%s echo for %q = %v, want Setpoint 42.5", dataType, echo.deviceID, echo.payload.Metrics)
+			}
+			if c.RejectedWrites() != 0 {
+				t.Errorf("RejectedWrites() = %d, want 0", c.RejectedWrites())
+			}
+		})
+	}
+}
+
+func TestBuiltinCommandsAreWritable(t *testing.T) {
+	loop := NewLoopback()
+	c := newTestClient(t, loop, Config{})
+	c.Config.ScanRate = time.Second
+	connectTestClient(t, c)
+
+	for _, name := range []string{"Node Control/Rebirth", "Node Control/Reboot", scanRateMetric} {
+		definition, ok := c.NodeMetrics().Definition(name)
+		if !ok || !definition.Writable {
+			t.Errorf("Definition(%s) = %+v, %t, want a writable metric", name, definition, ok)
+		}
+	}
+}
+
+func TestCommandHandlerErrorIsNotRejectedWrite(t *testing.T) {
+	loop := NewLoopback()
+	_, events := newLoopbackHost(t, loop)
+	c := newTestClient(t, loop, Config{ReportRejectedWrites: true})
+	if _, err := c.NodeMetrics().Declare(MetricDefinition{Name: "Setpoint", DataType: sproto.DataType_Double, Writable: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	called := make(chan struct{}, 1)
+	c.HandleNodeCommand("Setpoint", CommandHandler{
+		Echo: true,
+		Handle: func(cmd Command) error {
+			called <- struct{}{}
+			return errors.New("controller offline")
+		},
+	})
+
+	connectTestClient(t, c)
+	waitForEvent(t, events, MessageTypeNBIRTH)
+	publishCommand(t, loop, "spBv1.0/plant/NCMD/edge-1", commandMetric(t, "Setpoint", sproto.DataType_Double, 42.5))
+
+	select {
+	case <-called:
+	case <-time.After(loopbackTimeout):
+		t.Fatal("timed out waiting for the command handler")
+	}
+
+	expectNoEvent(t, events, MessageTypeNDATA, 50*time.Millisecond)
+	if c.RejectedWrites() != 0 {
+		t.Errorf("RejectedWrites() = %d, want 0 for a failing handler", c.RejectedWrites())
+	}
+}
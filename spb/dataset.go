@@ -0,0 +1,204 @@
+package spb
+
+import (
+	"fmt"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+type DataSet struct {
+	Columns []string
+	Types   []sproto.DataType
+	Rows    [][]any
+}
+
+func NewDataSet(columns []string, types []sproto.DataType) (*DataSet, error) {
+	d := &DataSet{
+		Columns: columns,
+		Types:   types,
+	}
+
+	if err := d.validateColumns(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (d *DataSet) AddRow(values ...any) error {
+	if err := d.validateRow(len(d.Rows), values); err != nil {
+		return err
+	}
+
+	d.Rows = append(d.Rows, values)
+
+	return nil
+}
+
+func (d *DataSet) Validate() error {
+	if err := d.validateColumns(); err != nil {
+		return err
+	}
+
+	for i, row := range d.Rows {
+		if err := d.validateRow(i, row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *DataSet) validateColumns() error {
+	if len(d.Columns) != len(d.Types) {
+		return fmt.Errorf("dataset has %d columns but %d types", len(d.Columns), len(d.Types))
+	}
+
+	for i, dataType := range d.Types {
+		if !isDataSetDataType(dataType) {
+			return fmt.Errorf("dataset column %s has unsupported datatype %s", d.Columns[i], dataType)
+		}
+	}
+
+	return nil
+}
+
+func (d *DataSet) validateRow(index int, values []any) error {
+	if len(values) != len(d.Columns) {
+		return fmt.Errorf("dataset row %d has %d values but %d columns", index, len(values), len(d.Columns))
+	}
+
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+
+		if _, ok := encodeDataSetValue(d.Types[i], value); !ok {
+			return fmt.Errorf("dataset row %d: %w", index, &MetricTypeError{Name: d.Columns[i], DataType: d.Types[i], Value: value})
+		}
+	}
+
+	return nil
+}
+
+func (d *DataSet) toProto() (*sproto.Payload_DataSet, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	types := make([]uint32, len(d.Types))
+	for i, dataType := range d.Types {
+		types[i] = uint32(dataType)
+	}
+
+	rows := make([]*sproto.Payload_DataSet_Row, len(d.Rows))
+	for i, row := range d.Rows {
+		elements := make([]*sproto.Payload_DataSet_DataSetValue, len(row))
+		for j, value := range row {
+			if value == nil {
+				elements[j] = &sproto.Payload_DataSet_DataSetValue{}
+				continue
+			}
+			elements[j], _ = encodeDataSetValue(d.Types[j], value)
+		}
+		rows[i] = &sproto.Payload_DataSet_Row{Elements: elements}
+	}
+
+	return &sproto.Payload_DataSet{
+		NumOfColumns: proto.Uint64(uint64(len(d.Columns))),
+		Columns:      append([]string(nil), d.Columns...),
+		Types:        types,
+		Rows:         rows,
+	}, nil
+}
+
+func DecodeDataSet(dataset *sproto.Payload_DataSet) (*DataSet, error) {
+	numColumns := int(dataset.GetNumOfColumns())
+	if len(dataset.GetColumns()) != numColumns || len(dataset.GetTypes()) != numColumns {
+		return nil, fmt.Errorf("dataset declares %d columns but has %d column names and %d types", numColumns, len(dataset.GetColumns()), len(dataset.GetTypes()))
+	}
+
+	types := make([]sproto.DataType, numColumns)
+	for i, dataType := range dataset.GetTypes() {
+		types[i] = sproto.DataType(dataType)
+	}
+
+	d, err := NewDataSet(append([]string(nil), dataset.GetColumns()...), types)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, row := range dataset.GetRows() {
+		if len(row.GetElements()) != numColumns {
+			return nil, fmt.Errorf("dataset row %d has %d values but %d columns", i, len(row.GetElements()), numColumns)
+		}
+
+		values := make([]any, numColumns)
+		for j, element := range row.GetElements() {
+			value, err := decodeDataSetValue(types[j], element)
+			if err != nil {
+				return nil, fmt.Errorf("dataset row %d column %s: %w", i, d.Columns[j], err)
+			}
+			values[j] = value
+		}
+		d.Rows = append(d.Rows, values)
+	}
+
+	return d, nil
+}
+
+func isDataSetDataType(dataType sproto.DataType) bool {
+	return dataType >= sproto.DataType_Int8 && dataType <= sproto.DataType_UUID
+}
+
+func encodeDataSetValue(dataType sproto.DataType, value any) (*sproto.Payload_DataSet_DataSetValue, bool) {
+	metricValue, ok := encodeValue(dataType, value)
+	if !ok {
+		return nil, false
+	}
+
+	element := &sproto.Payload_DataSet_DataSetValue{}
+	switch v := metricValue.(type) {
+	case *sproto.Payload_Metric_IntValue:
+		element.Value = &sproto.Payload_DataSet_DataSetValue_IntValue{IntValue: v.IntValue}
+	case *sproto.Payload_Metric_LongValue:
+		element.Value = &sproto.Payload_DataSet_DataSetValue_LongValue{LongValue: v.LongValue}
+	case *sproto.Payload_Metric_FloatValue:
+		element.Value = &sproto.Payload_DataSet_DataSetValue_FloatValue{FloatValue: v.FloatValue}
+	case *sproto.Payload_Metric_DoubleValue:
+		element.Value = &sproto.Payload_DataSet_DataSetValue_DoubleValue{DoubleValue: v.DoubleValue}
+	case *sproto.Payload_Metric_BooleanValue:
+		element.Value = &sproto.Payload_DataSet_DataSetValue_BooleanValue{BooleanValue: v.BooleanValue}
+	case *sproto.Payload_Metric_StringValue:
+		element.Value = &sproto.Payload_DataSet_DataSetValue_StringValue{StringValue: v.StringValue}
+	default:
+		return nil, false
+	}
+
+	return element, true
+}
+
+func decodeDataSetValue(dataType sproto.DataType, element *sproto.Payload_DataSet_DataSetValue) (any, error) {
+	metric := &sproto.Payload_Metric{Datatype: proto.Uint32(uint32(dataType))}
+	switch v := element.GetValue().(type) {
+	case nil:
+		return nil, nil
+	case *sproto.Payload_DataSet_DataSetValue_IntValue:
+		metric.Value = &sproto.Payload_Metric_IntValue{IntValue: v.IntValue}
+	case *sproto.Payload_DataSet_DataSetValue_LongValue:
+		metric.Value = &sproto.Payload_Metric_LongValue{LongValue: v.LongValue}
+	case *sproto.Payload_DataSet_DataSetValue_FloatValue:
+		metric.Value = &sproto.Payload_Metric_FloatValue{FloatValue: v.FloatValue}
+	case *sproto.Payload_DataSet_DataSetValue_DoubleValue:
+		metric.Value = &sproto.Payload_Metric_DoubleValue{DoubleValue: v.DoubleValue}
+	case *sproto.Payload_DataSet_DataSetValue_BooleanValue:
+		metric.Value = &sproto.Payload_Metric_BooleanValue{BooleanValue: v.BooleanValue}
+	case *sproto.Payload_DataSet_DataSetValue_StringValue:
+		metric.Value = &sproto.Payload_Metric_StringValue{StringValue: v.StringValue}
+	default:
+		return nil, fmt.Errorf("unsupported dataset value %T", v)
+	}
+
+	return MetricValue(metric)
+}
@@ -0,0 +1,197 @@
+package spb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDataSetRoundTrip(t *testing.T) {
+	columns := []string{"Step", "Offset", "Count", "Total", "Ratio", "Weight", "Enabled", "Label", "Started"}
+	types := []sproto.DataType{
+		sproto.DataType_Int8,
+		sproto.DataType_Int32,
+		sproto.DataType_UInt16,
+		sproto.DataType_UInt64,
+		sproto.DataType_Float,
+		sproto.DataType_Double,
+		sproto.DataType_Boolean,
+		sproto.DataType_String,
+		sproto.DataType_DateTime,
+	}
+
+	d, err := NewDataSet(columns, types)
+	if err != nil {
+		t.Fatalf("NewDataSet() error = %v", err)
+	}
+
+	rows := [][]any{
+		{int8(-5), int32(-70000), uint16(65535), uint64(1 << 63), float32(0.5), 12.25, true, "fill", time.UnixMilli(1700000000123).UTC()},
+		{int8(7), nil, uint16(0), uint64(0), float32(-1), nil, false, "", nil},
+	}
+	for _, row := range rows {
+		if err := d.AddRow(row...); err != nil {
+			t.Fatalf("AddRow(%v) error = %v", row, err)
+		}
+	}
+
+	metric, err := NewMetric("Recipe", sproto.DataType_DataSet, d)
+	if err != nil {
+		t.Fatalf("NewMetric() error = %v", err)
+	}
+
+	data, err := proto.Marshal(&sproto.Payload{Metrics: []*sproto.Payload_Metric{metric}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var payload sproto.Payload
+	if err := proto.Unmarshal(data, &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded := payload.Metrics[0].GetDatasetValue()
+	if encoded.GetNumOfColumns() != uint64(len(columns)) || len(encoded.GetTypes()) != len(types) || encoded.GetTypes()[0] != uint32(sproto.DataType_Int8) {
+		t.Errorf("encoded dataset header = %d columns, types %v", encoded.GetNumOfColumns(), encoded.GetTypes())
+	}
+	if got := encoded.GetRows()[0].GetElements()[0].GetIntValue(); got != 0xFB {
+		t.Errorf("encoded Int8 -5 = %#x, want 0xfb", got)
+	}
+
+	got, err := MetricValue(payload.Metrics[0])
+	if err != nil {
+		t.Fatalf("MetricValue() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(got, d) {
+		t.Errorf("MetricValue() = %#v, want %#v", got, d)
+	}
+}
+
+func TestNewDataSetValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns []string
+		types   []sproto.DataType
+	}{
+		{"more columns than types", []string{"A", "B"}, []sproto.DataType{sproto.DataType_Int32}},
+		{"more types than columns", []string{"A"}, []sproto.DataType{sproto.DataType_Int32, sproto.DataType_Int32}},
+		{"nested dataset", []string{"A"}, []sproto.DataType{sproto.DataType_DataSet}},
+		{"bytes column", []string{"A"}, []sproto.DataType{sproto.DataType_Bytes}},
+		{"unknown column", []string{"A"}, []sproto.DataType{sproto.DataType_Unknown}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewDataSet(tt.columns, tt.types); err == nil {
+				t.Error("NewDataSet() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestDataSetAddRowErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []any
+		typeErr  bool
+		wantType sproto.DataType
+	}{
+		{name: "too few values", values: []any{int8(1)}},
+		{name: "too many values", values: []any{int8(1), "a", true}},
+		{name: "string in int column", values: []any{"1", "a"}, typeErr: true, wantType: sproto.DataType_Int8},
+		{name: "int8 out of range", values: []any{300, "a"}, typeErr: true, wantType: sproto.DataType_Int8},
+		{name: "int in string column", values: []any{int8(1), 2}, typeErr: true, wantType: sproto.DataType_String},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := NewDataSet([]string{"Step", "Label"}, []sproto.DataType{sproto.DataType_Int8, sproto.DataType_String})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = d.AddRow(tt.values...)
+			if err == nil {
+				t.Fatal("AddRow() error = nil, want an error")
+			}
+			if len(d.Rows) != 0 {
+				t.Errorf("AddRow() kept %d rows after an error", len(d.Rows))
+			}
+
+			var typeErr *MetricTypeError
+			if errors.As(err, &typeErr) != tt.typeErr {
+				t.Fatalf("AddRow() error = %v, want MetricTypeError %t", err, tt.typeErr)
+			}
+			if tt.typeErr && typeErr.DataType != tt.wantType {
+				t.Errorf("MetricTypeError.DataType = %s, want %s", typeErr.DataType, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestDataSetValidateMismatchedRows(t *testing.T) {
+	d := &DataSet{
+		Columns: []string{"Step", "Label"},
+		Types:   []sproto.DataType{sproto.DataType_Int8, sproto.DataType_String},
+		Rows:    [][]any{{int8(1), "ok"}, {int8(2)}},
+	}
+
+	if err := d.Validate(); err == nil {
+		t.Error("Validate() error = nil, want an error for the short row")
+	}
+
+	if _, err := NewMetric("Recipe", sproto.DataType_DataSet, d); err == nil {
+		t.Error("NewMetric() error = nil, want an error for an invalid dataset")
+	}
+}
+
+func TestDecodeDataSetErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		dataset *sproto.Payload_DataSet
+	}{
+		{
+			name: "column count mismatch",
+			dataset: &sproto.Payload_DataSet{
+				NumOfColumns: proto.Uint64(2),
+				Columns:      []string{"Step"},
+				Types:        []uint32{uint32(sproto.DataType_Int8)},
+			},
+		},
+		{
+			name: "short row",
+			dataset: &sproto.Payload_DataSet{
+				NumOfColumns: proto.Uint64(2),
+				Columns:      []string{"Step", "Label"},
+				Types:        []uint32{uint32(sproto.DataType_Int8), uint32(sproto.DataType_String)},
+				Rows: []*sproto.Payload_DataSet_Row{{Elements: []*sproto.Payload_DataSet_DataSetValue{
+					{Value: &sproto.Payload_DataSet_DataSetValue_IntValue{IntValue: 1}},
+				}}},
+			},
+		},
+		{
+			name: "value does not match column type",
+			dataset: &sproto.Payload_DataSet{
+				NumOfColumns: proto.Uint64(1),
+				Columns:      []string{"Label"},
+				Types:        []uint32{uint32(sproto.DataType_String)},
+				Rows: []*sproto.Payload_DataSet_Row{{Elements: []*sproto.Payload_DataSet_DataSetValue{
+					{Value: &sproto.Payload_DataSet_DataSetValue_IntValue{IntValue: 1}},
+				}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeDataSet(tt.dataset); err == nil {
+				t.Error("DecodeDataSet() error = nil, want an error")
+			}
+		})
+	}
+}
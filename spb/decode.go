@@ -0,0 +1,137 @@
+package spb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+type DecodedPayload struct {
+	Timestamp time.Time
+	Seq       *uint64
+	UUID      string
+	Body      []byte
+	Metrics   []DecodedMetric
+}
+
+type DecodedMetric struct {
+	Name         string
+	Alias        *uint64
+	Timestamp    time.Time
+	DataType     sproto.DataType
+	Value        any
+	IsNull       bool
+	IsHistorical bool
+	IsTransient  bool
+	Properties   PropertySet
+}
+
+type MalformedPayloadError struct {
+	Err error
+}
+
+func (e *MalformedPayloadError) Error() string {
+	return fmt.Sprintf("malformed Sparkplug payload: %v", e.Err)
+}
+
+func (e *MalformedPayloadError) Unwrap() error {
+	return e.Err
+}
+
+type MetricDecodeError struct {
+	Name     string
+	DataType sproto.DataType
+	Err      error
+}
+
+func (e *MetricDecodeError) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("failed to decode %s value: %v", e.DataType, e.Err)
+	}
+
+	return fmt.Sprintf("failed to decode metric %s with datatype %s: %v", e.Name, e.DataType, e.Err)
+}
+
+func (e *MetricDecodeError) Unwrap() error {
+	return e.Err
+}
+
+func Decode(data []byte) (*DecodedPayload, error) {
+	payload, err := unmarshalPayload(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodePayload(payload)
+}
+
+func DecodePayload(payload *sproto.Payload) (*DecodedPayload, error) {
+	decoded := &DecodedPayload{
+		Seq:     payload.Seq,
+		UUID:    payload.GetUuid(),
+		Body:    payload.GetBody(),
+		Metrics: make([]DecodedMetric, 0, len(payload.GetMetrics())),
+	}
+
+	if payload.Timestamp != nil {
+		decoded.Timestamp = time.UnixMilli(int64(payload.GetTimestamp())).UTC()
+	}
+
+	for i, metric := range payload.GetMetrics() {
+		m, err := DecodeMetric(metric)
+		if err != nil {
+			return nil, fmt.Errorf("metric %d: %w", i, err)
+		}
+		decoded.Metrics = append(decoded.Metrics, m)
+	}
+
+	return decoded, nil
+}
+
+func DecodeMetric(metric *sproto.Payload_Metric) (DecodedMetric, error) {
+	dataType := sproto.DataType(metric.GetDatatype())
+	m := DecodedMetric{
+		Name:         metric.GetName(),
+		Alias:        metric.Alias,
+		DataType:     dataType,
+		IsNull:       metric.GetIsNull(),
+		IsHistorical: metric.GetIsHistorical(),
+		IsTransient:  metric.GetIsTransient(),
+	}
+
+	if metric.Timestamp != nil {
+		m.Timestamp = time.UnixMilli(int64(metric.GetTimestamp())).UTC()
+	}
+
+	properties, err := MetricProperties(metric)
+	if err != nil {
+		return DecodedMetric{}, &MetricDecodeError{Name: m.Name, DataType: dataType, Err: err}
+	}
+	m.Properties = properties
+
+	if m.IsNull {
+		return m, nil
+	}
+
+	if dataType == sproto.DataType_Unknown {
+		return DecodedMetric{}, &MetricDecodeError{Name: m.Name, DataType: dataType, Err: fmt.Errorf("metric has no datatype")}
+	}
+
+	m.Value, err = typedMetricValue(dataType, metric)
+	if err != nil {
+		return DecodedMetric{}, err
+	}
+
+	return m, nil
+}
+
+func unmarshalPayload(data []byte) (*sproto.Payload, error) {
+	var payload sproto.Payload
+	if err := proto.Unmarshal(data, &payload); err != nil {
+		return nil, &MalformedPayloadError{Err: err}
+	}
+
+	return &payload, nil
+}
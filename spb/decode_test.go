@@ -0,0 +1,230 @@
+package spb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDecode(t *testing.T) {
+	dataset, err := NewDataSet([]string{"Step"}, []sproto.DataType{sproto.DataType_Int16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dataset.AddRow(int16(-5)); err != nil {
+		t.Fatal(err)
+	}
+
+	timestamp := time.UnixMilli(1700000000123).UTC()
+	payload := &sproto.Payload{
+		Timestamp: proto.Uint64(uint64(timestamp.UnixMilli())),
+		Seq:       proto.Uint64(7),
+		Uuid:      proto.String("schema"),
+	}
+
+	values := []struct {
+		name     string
+		dataType sproto.DataType
+		value    any
+	}{
+		{"Int8", sproto.DataType_Int8, int8(-1)},
+		{"Int64", sproto.DataType_Int64, int64(-9000000000)},
+		{"DateTime", sproto.DataType_DateTime, timestamp},
+		{"Waveform", sproto.DataType_FloatArray, []float32{-1.5, 2}},
+		{"Recipe", sproto.DataType_DataSet, dataset},
+	}
+	for _, v := range values {
+		metric, err := NewMetric(v.name, v.dataType, v.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload.Metrics = append(payload.Metrics, metric)
+	}
+
+	null := newNullMetric("Missing", sproto.DataType_String)
+	null.Properties, err = PropertySet{PropertyEngUnit: {DataType: sproto.DataType_String, Value: "degC"}}.toProto()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload.Metrics = append(payload.Metrics, null)
+
+	data, err := proto.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	if !decoded.Timestamp.Equal(timestamp) || decoded.Seq == nil || *decoded.Seq != 7 || decoded.UUID != "schema" {
+		t.Errorf("Decode() header = %v, %v, %q", decoded.Timestamp, decoded.Seq, decoded.UUID)
+	}
+
+	if len(decoded.Metrics) != len(values)+1 {
+		t.Fatalf("Decode() returned %d metrics, want %d", len(decoded.Metrics), len(values)+1)
+	}
+
+	for i, v := range values {
+		m := decoded.Metrics[i]
+		if m.Name != v.name || m.DataType != v.dataType || !reflect.DeepEqual(m.Value, v.value) {
+			t.Errorf("metric %d = %s %s %#v, want %s %s %#v", i, m.Name, m.DataType, m.Value, v.name, v.dataType, v.value)
+		}
+	}
+
+	missing := decoded.Metrics[len(values)]
+	if !missing.IsNull || missing.Value != nil {
+		t.Errorf("null metric = %#v, want a nil value", missing)
+	}
+	if unit := missing.Properties[PropertyEngUnit]; unit.Value != "degC" {
+		t.Errorf("null metric engUnit = %#v, want degC", unit)
+	}
+}
+
+func TestDecodeMetricSignHandling(t *testing.T) {
+	tests := []struct {
+		name     string
+		dataType sproto.DataType
+		value    sproto.Payload_Metric_Value
+		want     any
+	}{
+		{"int8 sign extended", sproto.DataType_Int8, &sproto.Payload_Metric_IntValue{IntValue: 0xFFFFFF80}, int8(-128)},
+		{"int8 low byte", sproto.DataType_Int8, &sproto.Payload_Metric_IntValue{IntValue: 0xFE}, int8(-2)},
+		{"int8 positive", sproto.DataType_Int8, &sproto.Payload_Metric_IntValue{IntValue: 0x7F}, int8(127)},
+		{"int16 sign extended", sproto.DataType_Int16, &sproto.Payload_Metric_IntValue{IntValue: 0xFFFF8AD0}, int16(-30000)},
+		{"int16 low bytes", sproto.DataType_Int16, &sproto.Payload_Metric_IntValue{IntValue: 0x8AD0}, int16(-30000)},
+		{"int32", sproto.DataType_Int32, &sproto.Payload_Metric_IntValue{IntValue: 0x80000000}, int32(-2147483648)},
+		{"int64", sproto.DataType_Int64, &sproto.Payload_Metric_LongValue{LongValue: 0xFFFFFFFFFFFFFFFE}, int64(-2)},
+		{"uint32 untouched", sproto.DataType_UInt32, &sproto.Payload_Metric_IntValue{IntValue: 0xFFFFFFFF}, uint32(0xFFFFFFFF)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metric := &sproto.Payload_Metric{Name: proto.String(tt.name), Datatype: proto.Uint32(uint32(tt.dataType)), Value: tt.value}
+
+			got, err := DecodeMetric(metric)
+			if err != nil {
+				t.Fatalf("DecodeMetric() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got.Value, tt.want) {
+				t.Errorf("DecodeMetric() value = %#v, want %#v", got.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	metricPayload := func(metric *sproto.Payload_Metric) []byte {
+		data, err := proto.Marshal(&sproto.Payload{Metrics: []*sproto.Payload_Metric{metric}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		target any
+	}{
+		{
+			name:   "truncated protobuf",
+			data:   []byte{0x0A, 0x05, 0x01},
+			target: new(*MalformedPayloadError),
+		},
+		{
+			name:   "invalid wire type",
+			data:   []byte{0xFF, 0xFF},
+			target: new(*MalformedPayloadError),
+		},
+		{
+			name: "value does not match datatype",
+			data: metricPayload(&sproto.Payload_Metric{
+				Name:     proto.String("Speed"),
+				Datatype: proto.Uint32(uint32(sproto.DataType_Double)),
+				Value:    &sproto.Payload_Metric_IntValue{IntValue: 1},
+			}),
+			target: new(*MetricTypeError),
+		},
+		{
+			name: "int8 out of range",
+			data: metricPayload(&sproto.Payload_Metric{
+				Name:     proto.String("Level"),
+				Datatype: proto.Uint32(uint32(sproto.DataType_Int8)),
+				Value:    &sproto.Payload_Metric_IntValue{IntValue: 0x1234},
+			}),
+			target: new(*MetricDecodeError),
+		},
+		{
+			name: "uint16 out of range",
+			data: metricPayload(&sproto.Payload_Metric{
+				Name:     proto.String("Count"),
+				Datatype: proto.Uint32(uint32(sproto.DataType_UInt16)),
+				Value:    &sproto.Payload_Metric_IntValue{IntValue: 0x10000},
+			}),
+			target: new(*MetricDecodeError),
+		},
+		{
+			name: "invalid uuid",
+			data: metricPayload(&sproto.Payload_Metric{
+				Name:     proto.String("ID"),
+				Datatype: proto.Uint32(uint32(sproto.DataType_UUID)),
+				Value:    &sproto.Payload_Metric_StringValue{StringValue: "not-a-uuid"},
+			}),
+			target: new(*MetricDecodeError),
+		},
+		{
+			name: "truncated array",
+			data: metricPayload(&sproto.Payload_Metric{
+				Name:     proto.String("Waveform"),
+				Datatype: proto.Uint32(uint32(sproto.DataType_DoubleArray)),
+				Value:    &sproto.Payload_Metric_BytesValue{BytesValue: []byte{1, 2, 3}},
+			}),
+			target: new(*MetricDecodeError),
+		},
+		{
+			name: "malformed dataset",
+			data: metricPayload(&sproto.Payload_Metric{
+				Name:     proto.String("Recipe"),
+				Datatype: proto.Uint32(uint32(sproto.DataType_DataSet)),
+				Value: &sproto.Payload_Metric_DatasetValue{DatasetValue: &sproto.Payload_DataSet{
+					NumOfColumns: proto.Uint64(2),
+					Columns:      []string{"Step"},
+				}},
+			}),
+			target: new(*MetricDecodeError),
+		},
+		{
+			name:   "no datatype and no value",
+			data:   metricPayload(&sproto.Payload_Metric{Name: proto.String("Empty")}),
+			target: new(*MetricDecodeError),
+		},
+		{
+			name: "no datatype",
+			data: metricPayload(&sproto.Payload_Metric{
+				Name:  proto.String("Offset"),
+				Value: &sproto.Payload_Metric_IntValue{IntValue: 0xFFFFFFFF},
+			}),
+			target: new(*MetricDecodeError),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(tt.data)
+			if err == nil {
+				t.Fatal("Decode() error = nil, want an error")
+			}
+
+			if !errors.As(err, tt.target) {
+				t.Errorf("Decode() error = %v (%T), want %T", err, err, reflect.ValueOf(tt.target).Elem().Interface())
+			}
+		})
+	}
+}
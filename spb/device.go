@@ -0,0 +1,143 @@
+package spb
+
+import (
+	"fmt"
+	"log"
+)
+
+type DeviceNotBornError struct {
+	DeviceID string
+}
+
+func (e *DeviceNotBornError) Error() string {
+	return fmt.Sprintf("device %s has not published a DBIRTH", e.DeviceID)
+}
+
+type NodeNotBornError struct {
+	NodeID string
+}
+
+func (e *NodeNotBornError) Error() string {
+	return fmt.Sprintf("node %s has not published an NBIRTH", e.NodeID)
+}
+
+func (c *Client) AddDevice(device Device) error {
+	c.mu.Lock()
+	if _, ok := c.devices[device.GetId()]; ok {
+		c.mu.Unlock()
+		return fmt.Errorf("device %s is already registered", device.GetId())
+	}
+	c.registerDevice(device)
+	born := c.born
+	c.mu.Unlock()
+
+	if !born {
+		return nil
+	}
+
+	return c.PublishDBIRTH(device)
+}
+
+func (c *Client) RemoveDevice(deviceID string) error {
+	c.mu.Lock()
+	device, ok := c.devices[deviceID]
+	born := c.bornDevices[deviceID]
+	c.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("device %s is not registered", deviceID)
+	}
+
+	if born {
+		if err := c.PublishDDEATH(device); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	c.unregisterDevice(deviceID)
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *Client) Devices() []Device {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	devices := make([]Device, 0, len(c.deviceOrder))
+	for _, id := range c.deviceOrder {
+		devices = append(devices, c.devices[id])
+	}
+
+	return devices
+}
+
+func (c *Client) IsDeviceBorn(deviceID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bornDevices[deviceID]
+}
+
+func (c *Client) registerDevice(device Device) {
+	if _, ok := c.devices[device.GetId()]; !ok {
+		c.deviceOrder = append(c.deviceOrder, device.GetId())
+	}
+	c.devices[device.GetId()] = device
+}
+
+func (c *Client) unregisterDevice(deviceID string) {
+	if _, ok := c.devices[deviceID]; !ok {
+		return
+	}
+
+	delete(c.devices, deviceID)
+	delete(c.bornDevices, deviceID)
+	for i, id := range c.deviceOrder {
+		if id == deviceID {
+			c.deviceOrder = append(c.deviceOrder[:i], c.deviceOrder[i+1:]...)
+			break
+		}
+	}
+}
+
+func (c *Client) setDeviceBorn(device Device, born bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if born {
+		c.registerDevice(device)
+		c.bornDevices[device.GetId()] = true
+		return
+	}
+
+	delete(c.bornDevices, device.GetId())
+}
+
+func (c *Client) checkDeviceBorn(deviceID string) error {
+	if !c.IsDeviceBorn(deviceID) {
+		return &DeviceNotBornError{DeviceID: deviceID}
+	}
+
+	return nil
+}
+
+func (c *Client) checkNodeBorn() error {
+	c.mu.Lock()
+	born := c.born
+	c.mu.Unlock()
+
+	if !born {
+		return &NodeNotBornError{NodeID: c.Config.NodeID}
+	}
+
+	return nil
+}
+
+func (c *Client) rebirthDevices() {
+	for _, device := range c.Devices() {
+		if err := c.PublishDBIRTH(device); err != nil {
+			log.Printf("Failed to republish DBIRTH for device %s: %v", device.GetId(), err)
+		}
+	}
+}
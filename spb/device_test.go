@@ -0,0 +1,120 @@
+package spb
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type failingTransport struct {
+	Transport
+	fail *atomic.Bool
+}
+
+func (t *failingTransport) Publish(topic string, qos byte, retained bool, payload []byte, options PublishOptions) error {
+	if t.fail.Load() {
+		return errors.New("publish refused")
+	}
+
+	return t.Transport.Publish(topic, qos, retained, payload, options)
+}
+
+func TestDDEATHKeepsDeviceRegistered(t *testing.T) {
+	loop := NewLoopback()
+	_, events := newLoopbackHost(t, loop)
+	c := newTestClient(t, loop, Config{})
+	connectTestClient(t, c)
+	waitForEvent(t, events, MessageTypeNBIRTH)
+
+	device := &testDevice{id: "pump", values: map[string]any{"speed": 1.0}}
+	if err := c.AddDevice(device); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, events, MessageTypeDBIRTH)
+
+	if err := c.PublishDDEATH(device); err != nil {
+		t.Fatalf("PublishDDEATH() error = %v", err)
+	}
+	waitForEvent(t, events, MessageTypeDDEATH)
+
+	if c.IsDeviceBorn("pump") {
+		t.Error("IsDeviceBorn() = true after DDEATH")
+	}
+	if devices := c.Devices(); len(devices) != 1 || devices[0] != device {
+		t.Fatalf("Devices() = %v after DDEATH, want the pump", devices)
+	}
+
+	if err := c.PublishNBIRTH(); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, events, MessageTypeNBIRTH)
+	if birth := waitForEvent(t, events, MessageTypeDBIRTH); birth.deviceID != "pump" {
+		t.Errorf("DBIRTH device = %s, want pump", birth.deviceID)
+	}
+}
+
+func TestRemoveDevice(t *testing.T) {
+	loop := NewLoopback()
+	_, events := newLoopbackHost(t, loop)
+	c := newTestClient(t, loop, Config{})
+	connectTestClient(t, c)
+	waitForEvent(t, events, MessageTypeNBIRTH)
+
+	device := &testDevice{id: "pump", values: map[string]any{"speed": 1.0}}
+	if err := c.AddDevice(device); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, events, MessageTypeDBIRTH)
+
+	if err := c.RemoveDevice("pump"); err != nil {
+		t.Fatalf("RemoveDevice() error = %v", err)
+	}
+	waitForEvent(t, events, MessageTypeDDEATH)
+
+	if devices := c.Devices(); len(devices) != 0 {
+		t.Errorf("Devices() = %v after RemoveDevice(), want none", devices)
+	}
+	if err := c.RemoveDevice("pump"); err == nil {
+		t.Error("RemoveDevice() of an unknown device error = nil, want an error")
+	}
+
+	if err := c.PublishNBIRTH(); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, events, MessageTypeNBIRTH)
+	expectNoEvent(t, events, MessageTypeDBIRTH, 50*time.Millisecond)
+}
+
+func TestRemoveDeviceKeepsDeviceWhenDDEATHFails(t *testing.T) {
+	loop := NewLoopback()
+	_, events := newLoopbackHost(t, loop)
+	c := newTestClient(t, loop, Config{})
+
+	fail := &atomic.Bool{}
+	c.Config.Transport = func(config TransportConfig) (Transport, error) {
+		transport, err := loop.Transport(config)
+		if err != nil {
+			return nil, err
+		}
+		return &failingTransport{Transport: transport, fail: fail}, nil
+	}
+	connectTestClient(t, c)
+	waitForEvent(t, events, MessageTypeNBIRTH)
+
+	device := &testDevice{id: "pump", values: map[string]any{"speed": 1.0}}
+	if err := c.AddDevice(device); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, events, MessageTypeDBIRTH)
+
+	fail.Store(true)
+	if err := c.RemoveDevice("pump"); err == nil {
+		t.Fatal("RemoveDevice() error = nil, want the DDEATH publish error")
+	}
+	fail.Store(false)
+
+	if !c.IsDeviceBorn("pump") || len(c.Devices()) != 1 {
+		t.Errorf("device born %t, devices %v after a failed RemoveDevice(), want it still born and registered", c.IsDeviceBorn("pump"), c.Devices())
+	}
+}
@@ -0,0 +1,296 @@
+package spb
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+)
+
+type reportedValue struct {
+	value any
+	at    time.Time
+}
+
+func (c *Client) ReportNDATA(metricValues map[string]any) error {
+	now := time.Now()
+	changed, previous := c.nodeMetrics.claimExceptions(metricValues, c.Config.MaxSilence, now)
+	if len(changed) == 0 {
+		return nil
+	}
+
+	if err := c.PublishNDATA(changed); err != nil {
+		c.nodeMetrics.releaseReported(changed, previous, now)
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) ReportDDATA(device Device, metricValues map[string]any) error {
+	registry, ok := c.deviceRegistry(device.GetId())
+	if !ok {
+		return fmt.Errorf("failed to report DDATA: no metrics declared for device %s", device.GetId())
+	}
+
+	now := time.Now()
+	changed, previous := registry.claimExceptions(metricValues, c.Config.MaxSilence, now)
+	if len(changed) == 0 {
+		return nil
+	}
+
+	if err := c.PublishDDATA(device, changed); err != nil {
+		registry.releaseReported(changed, previous, now)
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) ReportDevice(device Device) error {
+	return c.ReportDDATA(device, device.GetMetricValues())
+}
+
+func (c *Client) resetReported() {
+	c.nodeMetrics.resetReported()
+
+	c.mu.Lock()
+	registries := make([]*MetricRegistry, 0, len(c.deviceMetrics))
+	for _, registry := range c.deviceMetrics {
+		registries = append(registries, registry)
+	}
+	c.mu.Unlock()
+
+	for _, registry := range registries {
+		registry.resetReported()
+	}
+}
+
+func (c *Client) startHeartbeat() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Config.MaxSilence <= 0 || c.heartbeatStop != nil {
+		return
+	}
+
+	c.heartbeatStop = make(chan struct{})
+	go c.heartbeatLoop(c.heartbeatStop)
+}
+
+func (c *Client) stopHeartbeat() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.heartbeatStop != nil {
+		close(c.heartbeatStop)
+		c.heartbeatStop = nil
+	}
+}
+
+func (c *Client) heartbeatLoop(stop <-chan struct{}) {
+	timer := time.NewTimer(c.Config.MaxSilence)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+		}
+
+		timer.Reset(c.heartbeat(time.Now()))
+	}
+}
+
+func (c *Client) heartbeat(now time.Time) time.Duration {
+	maxSilence := c.Config.MaxSilence
+	next := maxSilence
+
+	c.mu.Lock()
+	born := c.born
+	c.mu.Unlock()
+	if !born {
+		return next
+	}
+
+	due, previous, wait := c.nodeMetrics.claimSilent(maxSilence, now)
+	if wait > 0 && wait < next {
+		next = wait
+	}
+	if len(due) > 0 {
+		if err := c.publishNDATA(orderedValues(due), nil); err != nil {
+			log.Printf("Failed to publish NDATA heartbeat: %v", err)
+			c.nodeMetrics.releaseReported(due, previous, now)
+		}
+	}
+
+	for _, device := range c.Devices() {
+		if !c.IsDeviceBorn(device.GetId()) {
+			continue
+		}
+
+		registry, ok := c.deviceRegistry(device.GetId())
+		if !ok {
+			continue
+		}
+
+		due, previous, wait := registry.claimSilent(maxSilence, now)
+		if wait > 0 && wait < next {
+			next = wait
+		}
+		if len(due) == 0 {
+			continue
+		}
+
+		if err := c.publishDDATA(device, orderedValues(due), nil); err != nil {
+			log.Printf("Failed to publish DDATA heartbeat for device %s: %v", device.GetId(), err)
+			registry.releaseReported(due, previous, now)
+		}
+	}
+
+	return next
+}
+
+func (r *MetricRegistry) claimSilent(maxSilence time.Duration, now time.Time) (map[string]any, map[string]reportedValue, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	due := make(map[string]any)
+	previous := make(map[string]reportedValue)
+	var wait time.Duration
+	for name, last := range r.reported {
+		remaining := maxSilence - now.Sub(last.at)
+		if remaining <= 0 {
+			value, ok := r.values[name]
+			if !ok {
+				value = last.value
+			}
+			due[name] = value
+			previous[name] = last
+			r.reported[name] = reportedValue{value: value, at: now}
+			continue
+		}
+
+		if wait == 0 || remaining < wait {
+			wait = remaining
+		}
+	}
+
+	return due, previous, wait
+}
+
+func (r *MetricRegistry) claimExceptions(metricValues map[string]any, maxSilence time.Duration, now time.Time) (map[string]any, map[string]reportedValue) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := make(map[string]any, len(metricValues))
+	previous := make(map[string]reportedValue, len(metricValues))
+	for name, update := range metricValues {
+		definition, ok := r.definitions[name]
+		last, reported := r.reported[name]
+		value, properties := splitProperties(update)
+
+		switch {
+		case !ok, !reported, len(properties) > 0:
+		case maxSilence > 0 && now.Sub(last.at) >= maxSilence:
+		case !exceedsDeadband(*definition, last.value, value):
+			continue
+		}
+
+		changed[name] = update
+		if reported {
+			previous[name] = last
+		}
+		r.reported[name] = reportedValue{value: value, at: now}
+	}
+
+	return changed, previous
+}
+
+func (r *MetricRegistry) releaseReported(claimed map[string]any, previous map[string]reportedValue, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for name := range claimed {
+		if current, ok := r.reported[name]; !ok || !current.at.Equal(at) {
+			continue
+		}
+
+		if last, ok := previous[name]; ok {
+			r.reported[name] = last
+		} else {
+			delete(r.reported, name)
+		}
+	}
+}
+
+func (r *MetricRegistry) resetReported() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.reported = make(map[string]reportedValue, len(r.values))
+	for name, value := range r.values {
+		if r.deviceID == "" && isNodeControlMetric(name) {
+			continue
+		}
+		r.reported[name] = reportedValue{value: value, at: now}
+	}
+}
+
+func isNodeControlMetric(name string) bool {
+	return name == "bdSeq" || strings.HasPrefix(name, "Node Control/")
+}
+
+func exceedsDeadband(definition MetricDefinition, previous, value any) bool {
+	oldValue, oldNumeric := toFloat64(previous)
+	newValue, newNumeric := toFloat64(value)
+	if !oldNumeric || !newNumeric {
+		if oldTime, ok := previous.(time.Time); ok {
+			if newTime, ok := value.(time.Time); ok {
+				return !oldTime.Equal(newTime)
+			}
+		}
+
+		return !reflect.DeepEqual(previous, value)
+	}
+
+	if oldValue == newValue {
+		return false
+	}
+
+	diff := math.Abs(newValue - oldValue)
+	if definition.Deadband > 0 && diff <= definition.Deadband {
+		return false
+	}
+
+	if definition.DeadbandPercent > 0 && diff <= math.Abs(oldValue)*definition.DeadbandPercent/100 {
+		return false
+	}
+
+	return true
+}
+
+func validateDeadband(definition MetricDefinition) error {
+	if definition.Deadband < 0 || definition.DeadbandPercent < 0 {
+		return fmt.Errorf("metric %s must not declare a negative deadband", definition.Name)
+	}
+
+	if definition.Deadband == 0 && definition.DeadbandPercent == 0 {
+		return nil
+	}
+
+	switch definition.DataType {
+	case sproto.DataType_Int8, sproto.DataType_Int16, sproto.DataType_Int32, sproto.DataType_Int64,
+		sproto.DataType_UInt8, sproto.DataType_UInt16, sproto.DataType_UInt32, sproto.DataType_UInt64,
+		sproto.DataType_Float, sproto.DataType_Double:
+		return nil
+	default:
+		return fmt.Errorf("metric %s declares a deadband but has non-numeric datatype %s", definition.Name, definition.DataType)
+	}
+}
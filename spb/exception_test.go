@@ -0,0 +1,160 @@
+package spb
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+)
+
+func TestExceedsDeadband(t *testing.T) {
+	start := time.UnixMilli(1700000000000)
+
+	tests := []struct {
+		name       string
+		definition MetricDefinition
+		previous   any
+		value      any
+		want       bool
+	}{
+		{name: "unchanged", previous: 20.0, value: 20.0},
+		{name: "any change without deadband", previous: 20.0, value: 20.01, want: true},
+		{name: "inside absolute deadband", definition: MetricDefinition{Deadband: 0.5}, previous: 20.0, value: 20.5},
+		{name: "outside absolute deadband", definition: MetricDefinition{Deadband: 0.5}, previous: 20.0, value: 19.4, want: true},
+		{name: "inside percent deadband", definition: MetricDefinition{DeadbandPercent: 2}, previous: int32(200), value: int32(204)},
+		{name: "outside percent deadband", definition: MetricDefinition{DeadbandPercent: 2}, previous: int32(200), value: int32(205), want: true},
+		{name: "both deadbands must be exceeded", definition: MetricDefinition{Deadband: 5, DeadbandPercent: 1}, previous: 100.0, value: 103.0},
+		{name: "mixed numeric types", previous: int64(3), value: 3.0},
+		{name: "string changed", previous: "open", value: "closed", want: true},
+		{name: "same instant", previous: start, value: start.UTC()},
+		{name: "later instant", previous: start, value: start.Add(time.Millisecond), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exceedsDeadband(tt.definition, tt.previous, tt.value); got != tt.want {
+				t.Errorf("exceedsDeadband(%v, %v) = %t, want %t", tt.previous, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaimExceptions(t *testing.T) {
+	r := newMetricRegistry("", newAliasTable(), newTemplateRegistry())
+	if _, err := r.Declare(MetricDefinition{Name: "Temperature", DataType: sproto.DataType_Double, Deadband: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.update(map[string]any{"Temperature": 20.0}); err != nil {
+		t.Fatal(err)
+	}
+	r.resetReported()
+
+	start := time.Now()
+	steps := []struct {
+		name    string
+		value   any
+		at      time.Duration
+		changed bool
+	}{
+		{name: "birth value is the baseline", value: 20.4},
+		{name: "outside the deadband", value: 20.6, changed: true},
+		{name: "compared with the last report", value: 21.0},
+		{name: "properties always publish", value: MetricUpdate{Value: 21.0, Properties: PropertySet{PropertyQuality: {DataType: sproto.DataType_Int32, Value: QualityStale}}}, changed: true},
+		{name: "silent too long", value: 21.0, at: 2 * time.Minute, changed: true},
+	}
+
+	for _, step := range steps {
+		changed, _ := r.claimExceptions(map[string]any{"Temperature": step.value}, time.Minute, start.Add(step.at))
+		if _, ok := changed["Temperature"]; ok != step.changed {
+			t.Errorf("%s: claimExceptions() = %v, want changed %t", step.name, changed, step.changed)
+		}
+	}
+
+	at := start.Add(3 * time.Minute)
+	changed, previous := r.claimExceptions(map[string]any{"Temperature": 30.0}, 0, at)
+	r.releaseReported(changed, previous, at)
+	if changed, _ := r.claimExceptions(map[string]any{"Temperature": 30.0}, 0, at); len(changed) != 1 {
+		t.Errorf("claimExceptions() after a released claim = %v, want the value claimed again", changed)
+	}
+}
+
+func TestReportNDATADeadband(t *testing.T) {
+	loop := NewLoopback()
+	_, events := newLoopbackHost(t, loop)
+	c := newTestClient(t, loop, Config{})
+
+	if _, err := c.NodeMetrics().Declare(MetricDefinition{Name: "Temperature", DataType: sproto.DataType_Double, Deadband: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.nodeMetrics.update(map[string]any{"Temperature": 20.0}); err != nil {
+		t.Fatal(err)
+	}
+
+	connectTestClient(t, c)
+	waitForEvent(t, events, MessageTypeNBIRTH)
+
+	if err := c.ReportNDATA(map[string]any{"Temperature": 20.3}); err != nil {
+		t.Fatal(err)
+	}
+	expectNoEvent(t, events, MessageTypeNDATA, 50*time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.ReportNDATA(map[string]any{"Temperature": 20.6}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	data := waitForEvent(t, events, MessageTypeNDATA)
+	if metric, ok := findMetric(data.payload, "Temperature"); !ok || metric.GetDoubleValue() != 20.6 {
+		t.Errorf("NDATA = %v, want Temperature 20.6", data.payload.Metrics)
+	}
+	expectNoEvent(t, events, MessageTypeNDATA, 50*time.Millisecond)
+}
+
+func TestHeartbeatRepublishesBirthValues(t *testing.T) {
+	loop := NewLoopback()
+	_, events := newLoopbackHost(t, loop)
+	c := newTestClient(t, loop, Config{MaxSilence: time.Minute})
+
+	if _, err := c.NodeMetrics().Declare(MetricDefinition{Name: "Pressure", DataType: sproto.DataType_Int32}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.nodeMetrics.update(map[string]any{"Pressure": int32(7)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddDevice(&testDevice{id: "pump", values: map[string]any{"speed": 1200.0}}); err != nil {
+		t.Fatal(err)
+	}
+
+	connectTestClient(t, c)
+	waitForEvent(t, events, MessageTypeNBIRTH)
+	waitForEvent(t, events, MessageTypeDBIRTH)
+
+	now := time.Now()
+	if next := c.heartbeat(now); next <= 0 || next > time.Minute {
+		t.Errorf("heartbeat() right after the birth = %s, want the time left until MaxSilence", next)
+	}
+	expectNoEvent(t, events, MessageTypeNDATA, 50*time.Millisecond)
+
+	c.heartbeat(now.Add(2 * time.Minute))
+
+	data := waitForEvent(t, events, MessageTypeNDATA)
+	if metric, ok := findMetric(data.payload, "Pressure"); !ok || metric.GetIntValue() != 7 || len(data.payload.Metrics) != 1 {
+		t.Errorf("NDATA heartbeat = %v, want only Pressure 7", data.payload.Metrics)
+	}
+
+	ddata := waitForEvent(t, events, MessageTypeDDATA)
+	if metric, ok := findMetric(ddata.payload, "speed"); ddata.deviceID != "pump" || !ok || metric.GetDoubleValue() != 1200 {
+		t.Errorf("DDATA heartbeat for %s = %v, want pump speed 1200", ddata.deviceID, ddata.payload.Metrics)
+	}
+
+	c.heartbeat(now.Add(2 * time.Minute))
+	expectNoEvent(t, events, MessageTypeNDATA, 50*time.Millisecond)
+}
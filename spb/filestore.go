@@ -0,0 +1,272 @@
+package spb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	fileStoreHeaderSize = 8
+	fileStoreRecordSize = 6
+	fileStoreCompactMin = 64 * 1024
+)
+
+type fileRecord struct {
+	offset int64
+	length int64
+	size   int
+}
+
+type FileStore struct {
+	mu      sync.Mutex
+	path    string
+	limits  StoreLimits
+	file    *os.File
+	records []fileRecord
+	end     int64
+	bytes   int
+	dropped uint64
+}
+
+func NewFileStore(path string, limits StoreLimits) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open message store %s: %w", path, err)
+	}
+
+	s := &FileStore{
+		path:   path,
+		limits: limits,
+		file:   file,
+	}
+
+	if err := s.load(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to load message store %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+func (s *FileStore) load() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() < fileStoreHeaderSize {
+		return s.reset()
+	}
+
+	header := make([]byte, fileStoreHeaderSize)
+	if _, err := s.file.ReadAt(header, 0); err != nil {
+		return err
+	}
+
+	offset := int64(binary.LittleEndian.Uint64(header))
+	if offset < fileStoreHeaderSize || offset > info.Size() {
+		return fmt.Errorf("invalid head offset %d", offset)
+	}
+
+	prefix := make([]byte, fileStoreRecordSize)
+	for offset < info.Size() {
+		if _, err := s.file.ReadAt(prefix, offset); err != nil {
+			break
+		}
+
+		length := int64(binary.LittleEndian.Uint32(prefix))
+		topicLength := int(binary.LittleEndian.Uint16(prefix[4:]))
+		if int64(topicLength) > length || offset+fileStoreRecordSize+length > info.Size() {
+			break
+		}
+
+		s.records = append(s.records, fileRecord{offset: offset, length: fileStoreRecordSize + length, size: int(length)})
+		s.bytes += int(length)
+		offset += fileStoreRecordSize + length
+	}
+
+	if offset < info.Size() {
+		if err := s.file.Truncate(offset); err != nil {
+			return err
+		}
+	}
+	s.end = offset
+
+	return nil
+}
+
+func (s *FileStore) Push(msg StoredMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(msg.Topic) > 0xFFFF || s.limits.exceeded(1, msg.size()) {
+		s.dropped++
+		return ErrStoreFull
+	}
+
+	for s.limits.exceeded(len(s.records)+1, s.bytes+msg.size()) {
+		if s.limits.Policy == DropNewest {
+			s.dropped++
+			return ErrStoreFull
+		}
+		if err := s.pop(); err != nil {
+			return err
+		}
+		s.dropped++
+	}
+
+	record := make([]byte, fileStoreRecordSize+msg.size())
+	binary.LittleEndian.PutUint32(record, uint32(msg.size()))
+	binary.LittleEndian.PutUint16(record[4:], uint16(len(msg.Topic)))
+	copy(record[fileStoreRecordSize:], msg.Topic)
+	copy(record[fileStoreRecordSize+len(msg.Topic):], msg.Payload)
+
+	if _, err := s.file.WriteAt(record, s.end); err != nil {
+		return fmt.Errorf("failed to write to message store %s: %w", s.path, err)
+	}
+
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync message store %s: %w", s.path, err)
+	}
+
+	s.records = append(s.records, fileRecord{offset: s.end, length: int64(len(record)), size: msg.size()})
+	s.end += int64(len(record))
+	s.bytes += msg.size()
+
+	return nil
+}
+
+func (s *FileStore) Peek() (StoredMessage, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.records) == 0 {
+		return StoredMessage{}, false, nil
+	}
+
+	record := s.records[0]
+	buf := make([]byte, record.length)
+	if _, err := s.file.ReadAt(buf, record.offset); err != nil && err != io.EOF {
+		return StoredMessage{}, false, fmt.Errorf("failed to read from message store %s: %w", s.path, err)
+	}
+
+	topicLength := int(binary.LittleEndian.Uint16(buf[4:]))
+	body := buf[fileStoreRecordSize:]
+
+	return StoredMessage{
+		Topic:   string(body[:topicLength]),
+		Payload: body[topicLength:],
+	}, true, nil
+}
+
+func (s *FileStore) Pop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pop()
+}
+
+func (s *FileStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.records)
+}
+
+func (s *FileStore) Dropped() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+func (s *FileStore) pop() error {
+	if len(s.records) == 0 {
+		return nil
+	}
+
+	s.bytes -= s.records[0].size
+	s.records = s.records[1:]
+
+	if len(s.records) == 0 {
+		return s.reset()
+	}
+
+	head := s.records[0].offset
+	if dead := head - fileStoreHeaderSize; dead > fileStoreCompactMin && dead > s.end-head {
+		return s.compact()
+	}
+
+	return s.writeHead(head)
+}
+
+func (s *FileStore) writeHead(offset int64) error {
+	header := make([]byte, fileStoreHeaderSize)
+	binary.LittleEndian.PutUint64(header, uint64(offset))
+	if _, err := s.file.WriteAt(header, 0); err != nil {
+		return fmt.Errorf("failed to update message store %s: %w", s.path, err)
+	}
+
+	return s.file.Sync()
+}
+
+func (s *FileStore) reset() error {
+	if err := s.file.Truncate(fileStoreHeaderSize); err != nil {
+		return fmt.Errorf("failed to truncate message store %s: %w", s.path, err)
+	}
+
+	s.records = nil
+	s.end = fileStoreHeaderSize
+	s.bytes = 0
+
+	return s.writeHead(fileStoreHeaderSize)
+}
+
+func (s *FileStore) compact() error {
+	head := s.records[0].offset
+	live := make([]byte, s.end-head)
+	if _, err := s.file.ReadAt(live, head); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read message store %s: %w", s.path, err)
+	}
+
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to compact message store %s: %w", s.path, err)
+	}
+
+	header := make([]byte, fileStoreHeaderSize)
+	binary.LittleEndian.PutUint64(header, fileStoreHeaderSize)
+	if _, err := tmp.Write(append(header, live...)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to compact message store %s: %w", s.path, err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to compact message store %s: %w", s.path, err)
+	}
+
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to compact message store %s: %w", s.path, err)
+	}
+
+	s.file.Close()
+	s.file = tmp
+
+	shift := head - fileStoreHeaderSize
+	for i := range s.records {
+		s.records[i].offset -= shift
+	}
+	s.end -= shift
+
+	return nil
+}
@@ -0,0 +1,254 @@
+package spb
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+type Metric[T any] struct {
+	registry   *MetricRegistry
+	device     string
+	definition MetricDefinition
+
+	mu      sync.Mutex
+	value   T
+	dirty   bool
+	version uint64
+}
+
+type metricHandle interface {
+	deviceID() string
+	Name() string
+	pending() (metricValue, uint64, bool)
+	published(version uint64)
+	apply(value any) (uint64, error)
+}
+
+func NewNodeMetric[T any](c *Client, definition MetricDefinition) (*Metric[T], error) {
+	return newMetricHandle[T](c, c.nodeMetrics, "", definition)
+}
+
+func NewDeviceMetric[T any](c *Client, deviceID string, definition MetricDefinition) (*Metric[T], error) {
+	return newMetricHandle[T](c, c.DeviceMetrics(deviceID), deviceID, definition)
+}
+
+func newMetricHandle[T any](c *Client, registry *MetricRegistry, deviceID string, definition MetricDefinition) (*Metric[T], error) {
+	var zero T
+	if _, ok := encodeValue(definition.DataType, zero); !ok && !isNilable(reflect.TypeOf(&zero).Elem()) {
+		return nil, fmt.Errorf("metric %s: Go type %T cannot hold datatype %s", definition.Name, zero, definition.DataType)
+	}
+
+	declared, err := registry.Declare(definition)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Metric[T]{
+		registry:   registry,
+		device:     deviceID,
+		definition: declared,
+	}
+
+	key := commandKey{deviceID: deviceID, name: declared.Name}
+	c.mu.Lock()
+	c.handles[key] = m
+	c.handleOrder = append(c.handleOrder, key)
+	c.mu.Unlock()
+
+	return m, nil
+}
+
+func (m *Metric[T]) Name() string {
+	return m.definition.Name
+}
+
+func (m *Metric[T]) Definition() MetricDefinition {
+	return m.definition
+}
+
+func (m *Metric[T]) Get() T {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.value
+}
+
+func (m *Metric[T]) Set(value T) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.registry.updateValues([]metricValue{{name: m.definition.Name, value: value}}); err != nil {
+		return err
+	}
+
+	m.value = value
+	m.dirty = true
+	m.version++
+
+	return nil
+}
+
+func (m *Metric[T]) IsDirty() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.dirty
+}
+
+func (m *Metric[T]) apply(value any) (uint64, error) {
+	v, ok := value.(T)
+	if !ok {
+		rv := reflect.ValueOf(value)
+		target := reflect.TypeOf(&v).Elem()
+		if _, numeric := numericGoTypes[m.definition.DataType]; !numeric || !rv.IsValid() || !rv.CanConvert(target) {
+			return 0, fmt.Errorf("cannot write %T to metric %s of type %s", value, m.definition.Name, target)
+		}
+
+		converted := rv.Convert(target)
+		if !converted.Convert(rv.Type()).Equal(rv) {
+			return 0, fmt.Errorf("value %v is out of range for metric %s of type %s", value, m.definition.Name, target)
+		}
+		v = converted.Interface().(T)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.registry.updateValues([]metricValue{{name: m.definition.Name, value: v}}); err != nil {
+		return 0, err
+	}
+
+	m.value = v
+	m.dirty = true
+	m.version++
+
+	return m.version, nil
+}
+
+func (m *Metric[T]) deviceID() string {
+	return m.device
+}
+
+func (m *Metric[T]) pending() (metricValue, uint64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return metricValue{name: m.definition.Name, value: m.value}, m.version, m.dirty
+}
+
+func (m *Metric[T]) published(version uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.version == version {
+		m.dirty = false
+	}
+}
+
+func (c *Client) handleFor(deviceID, name string) (metricHandle, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, ok := c.handles[commandKey{deviceID: deviceID, name: name}]
+	return h, ok
+}
+
+func (c *Client) orderedHandles() []metricHandle {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	handles := make([]metricHandle, 0, len(c.handleOrder))
+	for _, key := range c.handleOrder {
+		handles = append(handles, c.handles[key])
+	}
+
+	return handles
+}
+
+func (c *Client) dirtyHandles(deviceID string) map[metricHandle]uint64 {
+	dirty := make(map[metricHandle]uint64)
+	for _, h := range c.orderedHandles() {
+		if h.deviceID() != deviceID {
+			continue
+		}
+
+		if _, version, ok := h.pending(); ok {
+			dirty[h] = version
+		}
+	}
+
+	return dirty
+}
+
+func markPublished(versions map[metricHandle]uint64) {
+	for h, version := range versions {
+		h.published(version)
+	}
+}
+
+func (c *Client) PublishDirty() error {
+	handles := c.orderedHandles()
+
+	type batch struct {
+		handles  []metricHandle
+		versions []uint64
+		values   []metricValue
+	}
+
+	var order []string
+	batches := make(map[string]*batch)
+	for _, h := range handles {
+		value, version, dirty := h.pending()
+		if !dirty {
+			continue
+		}
+
+		b, ok := batches[h.deviceID()]
+		if !ok {
+			b = &batch{}
+			batches[h.deviceID()] = b
+			order = append(order, h.deviceID())
+		}
+		b.handles = append(b.handles, h)
+		b.versions = append(b.versions, version)
+		b.values = append(b.values, value)
+	}
+
+	var errs []error
+	for _, deviceID := range order {
+		b := batches[deviceID]
+
+		var err error
+		if deviceID == "" {
+			err = c.publishNDATA(b.values, nil)
+		} else {
+			c.mu.Lock()
+			device, ok := c.devices[deviceID]
+			c.mu.Unlock()
+			if !ok {
+				err = fmt.Errorf("failed to publish DDATA: device %s is not registered", deviceID)
+			} else {
+				err = c.publishDDATA(device, b.values, nil)
+			}
+		}
+
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for i, h := range b.handles {
+			h.published(b.versions[i])
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func isNilable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,288 @@
+package spb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+)
+
+func TestNewMetricHandleErrors(t *testing.T) {
+	c := NewClient(Config{GroupID: "g", NodeID: "n"})
+
+	if _, err := NewNodeMetric[string](c, MetricDefinition{Name: "Speed", DataType: sproto.DataType_Double}); err == nil {
+		t.Error("NewNodeMetric[string]() for a Double error = nil, want an error")
+	}
+
+	if _, err := NewNodeMetric[float64](c, MetricDefinition{Name: "Speed", DataType: sproto.DataType_Double}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewNodeMetric[float64](c, MetricDefinition{Name: "Speed", DataType: sproto.DataType_Double}); err == nil {
+		t.Error("NewNodeMetric() of a declared metric error = nil, want an error")
+	}
+}
+
+func TestMetricDirtyTracking(t *testing.T) {
+	c := NewClient(Config{GroupID: "g", NodeID: "n"})
+	speed, err := NewNodeMetric[int64](c, MetricDefinition{Name: "Speed", DataType: sproto.DataType_Int16})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if speed.IsDirty() {
+		t.Error("IsDirty() of a new handle = true, want false")
+	}
+
+	if err := speed.Set(40000); err == nil {
+		t.Error("Set() out of Int16 range error = nil, want an error")
+	}
+	if speed.IsDirty() || speed.Get() != 0 {
+		t.Errorf("handle after a rejected Set() = %v, dirty %t, want 0 and clean", speed.Get(), speed.IsDirty())
+	}
+
+	if err := speed.Set(1420); err != nil {
+		t.Fatal(err)
+	}
+	_, first, dirty := speed.pending()
+	if !dirty || speed.Get() != 1420 {
+		t.Fatalf("handle after Set() = %v, dirty %t, want 1420 and dirty", speed.Get(), dirty)
+	}
+	if value, _ := c.NodeMetrics().Value("Speed"); value != int64(1420) {
+		t.Errorf("registry value = %#v, want 1420", value)
+	}
+
+	if err := speed.Set(1450); err != nil {
+		t.Fatal(err)
+	}
+	speed.published(first)
+	if !speed.IsDirty() {
+		t.Error("IsDirty() after publishing an older version = false, want true")
+	}
+
+	_, second, _ := speed.pending()
+	speed.published(second)
+	if speed.IsDirty() {
+		t.Error("IsDirty() after publishing the current version = true, want false")
+	}
+}
+
+func TestMetricApply(t *testing.T) {
+	c := NewClient(Config{GroupID: "g", NodeID: "n"})
+	level, err := NewNodeMetric[int8](c, MetricDefinition{Name: "Level", DataType: sproto.DataType_Int8, Writable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		value   any
+		want    int8
+		wantErr bool
+	}{
+		{name: "exact type", value: int8(5), want: 5},
+		{name: "converted", value: int64(-7), want: -7},
+		{name: "out of range", value: int64(300), want: -7, wantErr: true},
+		{name: "wrong type", value: "high", want: -7, wantErr: true},
+		{name: "null", value: nil, want: -7, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := level.apply(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("apply(%v) error = %v, wantErr %t", tt.value, err, tt.wantErr)
+			}
+			if level.Get() != tt.want {
+				t.Errorf("Get() = %d, want %d", level.Get(), tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleFor(t *testing.T) {
+	c := NewClient(Config{GroupID: "g", NodeID: "n"})
+	node, err := NewNodeMetric[float64](c, MetricDefinition{Name: "Speed", DataType: sproto.DataType_Double})
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := NewDeviceMetric[float64](c, "pump", MetricDefinition{Name: "Speed", DataType: sproto.DataType_Double})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h, ok := c.handleFor("", "Speed"); !ok || h != node {
+		t.Errorf("handleFor(node Speed) = %v, %t, want the node handle", h, ok)
+	}
+	if h, ok := c.handleFor("pump", "Speed"); !ok || h != device {
+		t.Errorf("handleFor(pump Speed) = %v, %t, want the device handle", h, ok)
+	}
+	if _, ok := c.handleFor("valve", "Speed"); ok {
+		t.Error("handleFor(valve Speed) found a handle, want none")
+	}
+}
+
+func TestHandleWriteBeforeBirth(t *testing.T) {
+	loop := NewLoopback()
+	_, events := newLoopbackHost(t, loop)
+	c := newTestClient(t, loop, Config{})
+
+	speed, err := NewDeviceMetric[float64](c, "pump", MetricDefinition{Name: "speed", DataType: sproto.DataType_Double, Writable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connectTestClient(t, c)
+	waitForEvent(t, events, MessageTypeNBIRTH)
+
+	publishCommand(t, loop, "spBv1.0/plant/DCMD/edge-1/pump", commandMetric(t, "speed", sproto.DataType_Double, 1300.0))
+	waitUntil(t, "the write is applied", func() bool { return speed.Get() == 1300 })
+
+	if err := c.AddDevice(&testDevice{id: "pump", values: map[string]any{}}); err != nil {
+		t.Fatal(err)
+	}
+	birth := waitForEvent(t, events, MessageTypeDBIRTH)
+	if metric, ok := findMetric(birth.payload, "speed"); !ok || metric.GetDoubleValue() != 1300 {
+		t.Errorf("DBIRTH = %v, want speed 1300", birth.payload.Metrics)
+	}
+}
+
+func TestPublishDirty(t *testing.T) {
+	loop := NewLoopback()
+	_, events := newLoopbackHost(t, loop)
+	c := newTestClient(t, loop, Config{})
+
+	temperature, err := NewNodeMetric[float64](c, MetricDefinition{Name: "Temperature", DataType: sproto.DataType_Double})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pressure, err := NewNodeMetric[int32](c, MetricDefinition{Name: "Pressure", DataType: sproto.DataType_Int32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	speed, err := NewDeviceMetric[float64](c, "pump", MetricDefinition{Name: "speed", DataType: sproto.DataType_Double})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphan, err := NewDeviceMetric[bool](c, "valve", MetricDefinition{Name: "open", DataType: sproto.DataType_Boolean})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := temperature.Set(20.5); err != nil {
+		t.Fatal(err)
+	}
+
+	connectTestClient(t, c)
+	birth := waitForEvent(t, events, MessageTypeNBIRTH)
+	if metric, ok := findMetric(birth.payload, "Temperature"); !ok || metric.GetDoubleValue() != 20.5 {
+		t.Errorf("NBIRTH = %v, want Temperature 20.5", birth.payload.Metrics)
+	}
+	if temperature.IsDirty() {
+		t.Error("IsDirty() after the NBIRTH carried the value = true, want false")
+	}
+
+	if err := speed.Set(1200); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddDevice(&testDevice{id: "pump", values: map[string]any{}}); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, events, MessageTypeDBIRTH)
+	if speed.IsDirty() {
+		t.Error("IsDirty() after the DBIRTH carried the value = true, want false")
+	}
+
+	if err := pressure.Set(7); err != nil {
+		t.Fatal(err)
+	}
+	if err := speed.Set(1250); err != nil {
+		t.Fatal(err)
+	}
+	if err := orphan.Set(true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.PublishDirty(); err == nil {
+		t.Error("PublishDirty() error = nil, want an error for the unregistered valve")
+	}
+
+	data := waitForEvent(t, events, MessageTypeNDATA)
+	if len(data.payload.Metrics) != 1 || data.payload.Metrics[0].GetIntValue() != 7 {
+		t.Errorf("NDATA = %v, want only Pressure 7", data.payload.Metrics)
+	}
+	ddata := waitForEvent(t, events, MessageTypeDDATA)
+	if metric, ok := findMetric(ddata.payload, "speed"); ddata.deviceID != "pump" || !ok || metric.GetDoubleValue() != 1250 {
+		t.Errorf("DDATA for %s = %v, want pump speed 1250", ddata.deviceID, ddata.payload.Metrics)
+	}
+
+	if pressure.IsDirty() || speed.IsDirty() || !orphan.IsDirty() {
+		t.Errorf("dirty after PublishDirty() = pressure %t, speed %t, valve %t, want only the valve", pressure.IsDirty(), speed.IsDirty(), orphan.IsDirty())
+	}
+
+	c.PublishDirty()
+	expectNoEvent(t, events, MessageTypeNDATA, 50*time.Millisecond)
+}
+
+func TestHandleDeclaredAfterBirth(t *testing.T) {
+	tests := []struct {
+		name      string
+		deviceID  string
+		birthType string
+		dataType  string
+	}{
+		{name: "node metric", birthType: MessageTypeNBIRTH, dataType: MessageTypeNDATA},
+		{name: "device metric", deviceID: "pump", birthType: MessageTypeDBIRTH, dataType: MessageTypeDDATA},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loop := NewLoopback()
+			_, events := newLoopbackHost(t, loop)
+			c := newTestClient(t, loop, Config{})
+			connectTestClient(t, c)
+			waitForEvent(t, events, MessageTypeNBIRTH)
+			if err := c.AddDevice(&testDevice{id: "pump", values: map[string]any{"running": true}}); err != nil {
+				t.Fatal(err)
+			}
+			waitForEvent(t, events, MessageTypeDBIRTH)
+
+			definition := MetricDefinition{Name: "speed", DataType: sproto.DataType_Double}
+			var speed *Metric[float64]
+			var err error
+			if tt.deviceID == "" {
+				speed, err = NewNodeMetric[float64](c, definition)
+			} else {
+				speed, err = NewDeviceMetric[float64](c, tt.deviceID, definition)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := speed.Set(1200); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.PublishDirty(); err != nil {
+				t.Fatalf("PublishDirty() error = %v", err)
+			}
+
+			birth := waitForEvent(t, events, tt.birthType)
+			metric, ok := findMetric(birth.payload, "speed")
+			if birth.deviceID != tt.deviceID || !ok || metric.GetAlias() != *speed.Definition().Alias {
+				t.Fatalf("%s for %q = %v, want speed with alias %d", tt.birthType, birth.deviceID, birth.payload.Metrics, *speed.Definition().Alias)
+			}
+
+			data := waitForEvent(t, events, tt.dataType)
+			if metric, ok := findMetric(data.payload, "speed"); !ok || metric.GetDoubleValue() != 1200 {
+				t.Errorf("%s = %v, want speed 1200", tt.dataType, data.payload.Metrics)
+			}
+
+			if err := speed.Set(1250); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.PublishDirty(); err != nil {
+				t.Fatal(err)
+			}
+			waitForEvent(t, events, tt.dataType)
+			expectNoEvent(t, events, tt.birthType, 50*time.Millisecond)
+		})
+	}
+}
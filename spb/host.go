@@ -0,0 +1,360 @@
+package spb
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/tjeumaster/go-sparkplug/sproto"
+)
+
+type HostConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	ClientID string
+	HostID   string
+
+	Scheme string
+	Path   string
+	TLS    *TLSConfig
+
+	Transport TransportFactory
+}
+
+type NodeHandler func(groupID, nodeID string, payload *sproto.Payload)
+
+type DeviceHandler func(groupID, nodeID, deviceID string, payload *sproto.Payload)
+
+type HostApplication struct {
+	MqttClient mqtt.Client
+	Config     HostConfig
+	transport  Transport
+	mu         sync.RWMutex
+	onNBIRTH   NodeHandler
+	onNDEATH   NodeHandler
+	onNDATA    NodeHandler
+	onDBIRTH   DeviceHandler
+	onDDEATH   DeviceHandler
+	onDDATA    DeviceHandler
+	onSTATE    StateHandler
+	birthState State
+	stopping   bool
+	aliases    map[string]*aliasTable
+}
+
+func NewHostApplication(config HostConfig) *HostApplication {
+	return &HostApplication{
+		Config:  config,
+		aliases: make(map[string]*aliasTable),
+	}
+}
+
+func (h *HostApplication) OnNBIRTH(handler NodeHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onNBIRTH = handler
+}
+
+func (h *HostApplication) OnNDEATH(handler NodeHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onNDEATH = handler
+}
+
+func (h *HostApplication) OnNDATA(handler NodeHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onNDATA = handler
+}
+
+func (h *HostApplication) OnDBIRTH(handler DeviceHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onDBIRTH = handler
+}
+
+func (h *HostApplication) OnDDEATH(handler DeviceHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onDDEATH = handler
+}
+
+func (h *HostApplication) OnDDATA(handler DeviceHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onDDATA = handler
+}
+
+func (h *HostApplication) OnSTATE(handler StateHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onSTATE = handler
+}
+
+func (h *HostApplication) Connect() error {
+	broker := Broker{
+		Host:     h.Config.Host,
+		Port:     h.Config.Port,
+		Scheme:   h.Config.Scheme,
+		Path:     h.Config.Path,
+		Username: h.Config.Username,
+		Password: h.Config.Password,
+		TLS:      h.Config.TLS,
+	}
+
+	mqttBroker, err := broker.url()
+	if err != nil {
+		return fmt.Errorf("invalid broker configuration: %w", err)
+	}
+
+	h.mu.Lock()
+	h.stopping = false
+	h.mu.Unlock()
+
+	config := TransportConfig{
+		Broker:        broker,
+		ClientID:      h.Config.ClientID,
+		AutoReconnect: true,
+		Will:          h.will,
+		OnConnect:     h.onConnect,
+	}
+
+	var t Transport
+	if h.Config.Transport != nil {
+		t, err = h.Config.Transport(config)
+	} else {
+		t, err = newTransport(ProtocolMQTT311, config)
+	}
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	h.transport = t
+	h.MqttClient = nil
+	if v3, ok := t.(*pahoTransport); ok {
+		h.MqttClient = v3.client
+	}
+	h.mu.Unlock()
+
+	if err := t.Connect(); err != nil {
+		return err
+	}
+
+	log.Printf("Host application connected to MQTT broker at %s", mqttBroker)
+
+	return nil
+}
+
+func (h *HostApplication) will() (*WillMessage, error) {
+	if h.Config.HostID == "" {
+		return nil, nil
+	}
+
+	h.mu.Lock()
+	h.birthState = newState(true, time.Now())
+	timestamp := h.birthState.Timestamp
+	h.mu.Unlock()
+
+	payload, err := buildStatePayload(State{Online: false, Timestamp: timestamp})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build STATE death payload: %w", err)
+	}
+
+	return &WillMessage{
+		Topic:    stateTopic(h.Config.HostID),
+		Payload:  payload,
+		QoS:      1,
+		Retained: true,
+	}, nil
+}
+
+func (h *HostApplication) onConnect() {
+	subscription := Namespace + "/#"
+
+	t := h.currentTransport()
+	if t == nil {
+		return
+	}
+
+	if err := t.Subscribe(subscription, 1, h.onMessageReceived); err != nil {
+		log.Printf("Failed to subscribe to %s: %v", subscription, err)
+	}
+
+	if h.Config.HostID != "" {
+		if err := h.publishState(true); err != nil {
+			log.Printf("Failed to publish STATE birth on connect: %v", err)
+		}
+	}
+}
+
+func (h *HostApplication) currentTransport() Transport {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.transport
+}
+
+func (h *HostApplication) Disconnect() error {
+	t := h.currentTransport()
+	if t == nil || !t.IsConnected() {
+		return fmt.Errorf("MQTT client is not connected")
+	}
+
+	h.mu.Lock()
+	h.stopping = true
+	h.mu.Unlock()
+
+	if h.Config.HostID != "" {
+		if err := h.publishState(false); err != nil {
+			log.Printf("Failed to publish STATE death before disconnect: %v", err)
+		}
+	}
+
+	t.Disconnect()
+
+	h.mu.Lock()
+	h.transport = nil
+	h.MqttClient = nil
+	h.mu.Unlock()
+
+	log.Printf("Host application disconnected from MQTT broker")
+	return nil
+}
+
+func (h *HostApplication) publishState(online bool) error {
+	t := h.currentTransport()
+	if t == nil {
+		return fmt.Errorf("MQTT client is not connected")
+	}
+
+	h.mu.RLock()
+	state := State{Online: online, Timestamp: h.birthState.Timestamp}
+	h.mu.RUnlock()
+
+	payload, err := buildStatePayload(state)
+	if err != nil {
+		return err
+	}
+
+	topic := stateTopic(h.Config.HostID)
+	if err := t.Publish(topic, 1, true, payload, PublishOptions{}); err != nil {
+		return fmt.Errorf("failed to publish to topic %s: %w", topic, err)
+	}
+
+	log.Printf("Published STATE online=%t to topic %s", online, topic)
+
+	return nil
+}
+
+func (h *HostApplication) onMessageReceived(msg TransportMessage) {
+	topic, err := ParseTopic(msg.Topic)
+	if err != nil {
+		log.Printf("Ignoring message: %v", err)
+		return
+	}
+
+	if topic.MessageType == MessageTypeSTATE {
+		h.onStateReceived(topic.HostID, msg.Payload)
+		return
+	}
+
+	if topic.MessageType == MessageTypeNCMD || topic.MessageType == MessageTypeDCMD {
+		return
+	}
+
+	payload, err := unmarshalPayload(msg.Payload)
+	if err != nil {
+		log.Printf("Failed to decode %s payload on topic %s: %v", topic.MessageType, msg.Topic, err)
+		return
+	}
+
+	h.resolveAliases(topic, payload)
+	h.dispatch(topic, payload)
+}
+
+func (h *HostApplication) resolveAliases(topic Topic, payload *sproto.Payload) {
+	key := topic.GroupID + "/" + topic.NodeID
+
+	h.mu.Lock()
+	table, ok := h.aliases[key]
+	if !ok || topic.MessageType == MessageTypeNBIRTH {
+		table = newAliasTable()
+		h.aliases[key] = table
+	}
+	h.mu.Unlock()
+
+	switch topic.MessageType {
+	case MessageTypeNBIRTH, MessageTypeDBIRTH:
+		for _, metric := range payload.Metrics {
+			if metric.Name != nil && metric.Alias != nil {
+				table.set(topic.DeviceID, metric.GetName(), metric.GetAlias())
+			}
+		}
+
+	case MessageTypeNDATA, MessageTypeDDATA:
+		for _, metric := range payload.Metrics {
+			if !table.resolveMetric(topic.DeviceID, metric) {
+				log.Printf("Received unknown alias %d on topic %s", metric.GetAlias(), topic)
+			}
+		}
+	}
+}
+
+func (h *HostApplication) onStateReceived(hostID string, payloadBytes []byte) {
+	state, err := parseStatePayload(payloadBytes)
+	if err != nil {
+		log.Printf("Failed to decode STATE payload for host %s: %v", hostID, err)
+		return
+	}
+
+	h.mu.RLock()
+	handler := h.onSTATE
+	stopping := h.stopping
+	h.mu.RUnlock()
+
+	if hostID == h.Config.HostID && !state.Online && !stopping {
+		log.Printf("Received offline STATE for own host ID %s, republishing birth", hostID)
+		go func() {
+			if err := h.publishState(true); err != nil {
+				log.Printf("Failed to republish STATE birth: %v", err)
+			}
+		}()
+	}
+
+	if handler != nil {
+		handler(hostID, state)
+	}
+}
+
+func (h *HostApplication) dispatch(topic Topic, payload *sproto.Payload) {
+	h.mu.RLock()
+	var nodeHandler NodeHandler
+	var deviceHandler DeviceHandler
+	switch topic.MessageType {
+	case MessageTypeNBIRTH:
+		nodeHandler = h.onNBIRTH
+	case MessageTypeNDEATH:
+		nodeHandler = h.onNDEATH
+	case MessageTypeNDATA:
+		nodeHandler = h.onNDATA
+	case MessageTypeDBIRTH:
+		deviceHandler = h.onDBIRTH
+	case MessageTypeDDEATH:
+		deviceHandler = h.onDDEATH
+	case MessageTypeDDATA:
+		deviceHandler = h.onDDATA
+	}
+	h.mu.RUnlock()
+
+	if nodeHandler != nil {
+		nodeHandler(topic.GroupID, topic.NodeID, payload)
+	}
+
+	if deviceHandler != nil {
+		deviceHandler(topic.GroupID, topic.NodeID, topic.DeviceID, payload)
+	}
+}
@@ -0,0 +1,69 @@
+package spb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHostRepublishesOwnOfflineState(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset time.Duration
+	}{
+		{name: "stale death", offset: -time.Hour},
+		{name: "forged death", offset: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loop := NewLoopback()
+			newTestHost(t, HostConfig{Host: "loopback", Port: 1883, ClientID: "scada", HostID: "scada", Transport: loop.Transport}, loop.Retained)
+
+			birth := retainedState(t, loop, "scada")
+			if !birth.Online {
+				t.Fatalf("STATE = %+v, want online", birth)
+			}
+
+			death, err := buildStatePayload(newState(false, time.UnixMilli(birth.Timestamp).Add(tt.offset)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			loop.Publish(stateTopic("scada"), death, true)
+
+			waitUntil(t, "the host republishes its birth", func() bool {
+				state := retainedState(t, loop, "scada")
+				return state.Online && state.Timestamp == birth.Timestamp
+			})
+		})
+	}
+}
+
+func TestHostDisconnectLeavesOfflineState(t *testing.T) {
+	loop := NewLoopback()
+	h, _ := newTestHost(t, HostConfig{Host: "loopback", Port: 1883, ClientID: "scada", HostID: "scada", Transport: loop.Transport}, loop.Retained)
+
+	if err := h.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if state := retainedState(t, loop, "scada"); state.Online {
+		t.Errorf("STATE after Disconnect() = %+v, want offline", state)
+	}
+}
+
+func retainedState(t *testing.T, loop *Loopback, hostID string) State {
+	t.Helper()
+
+	payload, ok := loop.Retained(stateTopic(hostID))
+	if !ok {
+		t.Fatalf("no retained STATE for %s", hostID)
+	}
+
+	state, err := parseStatePayload(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return state
+}
@@ -0,0 +1,319 @@
+package spb
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+type Loopback struct {
+	mu       sync.Mutex
+	clients  map[string]*loopbackTransport
+	retained map[string]TransportMessage
+}
+
+func NewLoopback() *Loopback {
+	return &Loopback{
+		clients:  make(map[string]*loopbackTransport),
+		retained: make(map[string]TransportMessage),
+	}
+}
+
+func (l *Loopback) Transport(config TransportConfig) (Transport, error) {
+	return &loopbackTransport{loopback: l, config: config}, nil
+}
+
+func (l *Loopback) Publish(topic string, payload []byte, retained bool) {
+	l.route(TransportMessage{Topic: topic, Payload: payload}, retained)
+}
+
+func (l *Loopback) Retained(topic string) ([]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	msg, ok := l.retained[topic]
+	return msg.Payload, ok
+}
+
+func (l *Loopback) DropClient(clientID string) bool {
+	l.mu.Lock()
+	t, ok := l.clients[clientID]
+	l.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	t.drop(errors.New("connection to loopback broker dropped"), t.config.AutoReconnect)
+	return true
+}
+
+func (l *Loopback) attach(t *loopbackTransport) {
+	l.mu.Lock()
+	previous := l.clients[t.config.ClientID]
+	l.clients[t.config.ClientID] = t
+	l.mu.Unlock()
+
+	if previous != nil && previous != t {
+		previous.drop(fmt.Errorf("client ID %s connected from another transport", t.config.ClientID), false)
+	}
+}
+
+func (l *Loopback) detach(t *loopbackTransport) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.clients[t.config.ClientID] != t {
+		return false
+	}
+
+	delete(l.clients, t.config.ClientID)
+	return true
+}
+
+func (l *Loopback) route(msg TransportMessage, retained bool) {
+	l.mu.Lock()
+	if retained {
+		if len(msg.Payload) == 0 {
+			delete(l.retained, msg.Topic)
+		} else {
+			l.retained[msg.Topic] = msg
+		}
+	}
+
+	clients := make([]*loopbackTransport, 0, len(l.clients))
+	for _, t := range l.clients {
+		clients = append(clients, t)
+	}
+	l.mu.Unlock()
+
+	for _, t := range clients {
+		t.deliver(msg)
+	}
+}
+
+func (l *Loopback) retainedMatching(filter string) []TransportMessage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var messages []TransportMessage
+	for topic, msg := range l.retained {
+		if topicMatches(filter, topic) {
+			messages = append(messages, msg)
+		}
+	}
+
+	return messages
+}
+
+type loopbackSubscription struct {
+	filter  string
+	handler func(msg TransportMessage)
+}
+
+type loopbackDelivery struct {
+	msg     TransportMessage
+	handler func(msg TransportMessage)
+}
+
+type loopbackTransport struct {
+	loopback *Loopback
+	config   TransportConfig
+
+	mu            sync.Mutex
+	connected     bool
+	will          *WillMessage
+	subscriptions []loopbackSubscription
+	pending       []loopbackDelivery
+	wake          chan struct{}
+	done          chan struct{}
+}
+
+func (t *loopbackTransport) Connect() error {
+	var will *WillMessage
+	if t.config.Will != nil {
+		var err error
+		will, err = t.config.Will()
+		if err != nil {
+			return fmt.Errorf("failed to connect to loopback broker: %w", err)
+		}
+	}
+
+	t.mu.Lock()
+	if t.connected {
+		t.mu.Unlock()
+		return nil
+	}
+	t.connected = true
+	t.will = will
+	t.subscriptions = nil
+	t.pending = nil
+	t.wake = make(chan struct{}, 1)
+	t.done = make(chan struct{})
+	go t.run(t.wake, t.done)
+	t.mu.Unlock()
+
+	t.loopback.attach(t)
+
+	if t.config.OnConnect != nil {
+		go t.config.OnConnect()
+	}
+
+	return nil
+}
+
+func (t *loopbackTransport) Disconnect() {
+	if t.close() {
+		t.loopback.detach(t)
+	}
+}
+
+func (t *loopbackTransport) IsConnected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.connected
+}
+
+func (t *loopbackTransport) Publish(topic string, qos byte, retained bool, payload []byte, options PublishOptions) error {
+	if !t.IsConnected() {
+		return fmt.Errorf("MQTT client is not connected")
+	}
+
+	t.loopback.route(TransportMessage{Topic: topic, Payload: payload, UserProperties: options.UserProperties}, retained)
+
+	return nil
+}
+
+func (t *loopbackTransport) Subscribe(topic string, qos byte, handler func(msg TransportMessage)) error {
+	t.mu.Lock()
+	if !t.connected {
+		t.mu.Unlock()
+		return fmt.Errorf("MQTT client is not connected")
+	}
+	t.subscriptions = append(t.subscriptions, loopbackSubscription{filter: topic, handler: handler})
+	t.mu.Unlock()
+
+	for _, msg := range t.loopback.retainedMatching(topic) {
+		t.enqueue(loopbackDelivery{msg: msg, handler: handler})
+	}
+
+	return nil
+}
+
+func (t *loopbackTransport) deliver(msg TransportMessage) {
+	t.mu.Lock()
+	var deliveries []loopbackDelivery
+	for _, subscription := range t.subscriptions {
+		if topicMatches(subscription.filter, msg.Topic) {
+			deliveries = append(deliveries, loopbackDelivery{msg: msg, handler: subscription.handler})
+		}
+	}
+	t.mu.Unlock()
+
+	for _, delivery := range deliveries {
+		t.enqueue(delivery)
+	}
+}
+
+func (t *loopbackTransport) enqueue(delivery loopbackDelivery) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.connected {
+		return
+	}
+
+	t.pending = append(t.pending, delivery)
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (t *loopbackTransport) run(wake, done chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-wake:
+		}
+
+		for {
+			t.mu.Lock()
+			if len(t.pending) == 0 || t.done != done {
+				t.mu.Unlock()
+				break
+			}
+			delivery := t.pending[0]
+			t.pending = t.pending[1:]
+			t.mu.Unlock()
+
+			delivery.handler(delivery.msg)
+		}
+	}
+}
+
+func (t *loopbackTransport) close() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.connected {
+		return false
+	}
+
+	t.connected = false
+	t.subscriptions = nil
+	t.pending = nil
+	close(t.done)
+
+	return true
+}
+
+func (t *loopbackTransport) drop(err error, reconnect bool) {
+	t.mu.Lock()
+	will := t.will
+	t.mu.Unlock()
+
+	if !t.close() {
+		return
+	}
+	t.loopback.detach(t)
+
+	if will != nil {
+		t.loopback.route(TransportMessage{Topic: will.Topic, Payload: will.Payload}, will.Retained)
+	}
+
+	if t.config.OnConnectionLost != nil {
+		t.config.OnConnectionLost(err)
+	}
+
+	if reconnect {
+		go func() {
+			if err := t.Connect(); err != nil {
+				log.Printf("Failed to reconnect to loopback broker: %v", err)
+			}
+		}()
+	}
+}
+
+func topicMatches(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+
+		if i >= len(topicLevels) {
+			return false
+		}
+
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+
+	return len(filterLevels) == len(topicLevels)
+}
@@ -0,0 +1,437 @@
+package spb
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+const loopbackTimeout = 2 * time.Second
+
+type hostEvent struct {
+	messageType string
+	deviceID    string
+	payload     *sproto.Payload
+}
+
+type testDevice struct {
+	id     string
+	mu     sync.Mutex
+	values map[string]any
+}
+
+func (d *testDevice) GetId() string {
+	return d.id
+}
+
+func (d *testDevice) GetMetricValues() map[string]any {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	values := make(map[string]any, len(d.values))
+	for name, value := range d.values {
+		values[name] = value
+	}
+
+	return values
+}
+
+func (d *testDevice) SetMetricValue(name string, value any) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.values[name] = value
+	return nil
+}
+
+func newLoopbackHost(t *testing.T, loop *Loopback) (*HostApplication, <-chan hostEvent) {
+	return newTestHost(t, HostConfig{Host: "loopback", Port: 1883, ClientID: "host", HostID: "monitor", Transport: loop.Transport}, loop.Retained)
+}
+
+func newTestHost(t *testing.T, config HostConfig, retained func(topic string) ([]byte, bool)) (*HostApplication, <-chan hostEvent) {
+	t.Helper()
+
+	events := make(chan hostEvent, 64)
+	node := func(messageType string) NodeHandler {
+		return func(groupID, nodeID string, payload *sproto.Payload) {
+			events <- hostEvent{messageType: messageType, payload: payload}
+		}
+	}
+	device := func(messageType string) DeviceHandler {
+		return func(groupID, nodeID, deviceID string, payload *sproto.Payload) {
+			events <- hostEvent{messageType: messageType, deviceID: deviceID, payload: payload}
+		}
+	}
+
+	h := NewHostApplication(config)
+	h.OnNBIRTH(node(MessageTypeNBIRTH))
+	h.OnNDEATH(node(MessageTypeNDEATH))
+	h.OnNDATA(node(MessageTypeNDATA))
+	h.OnDBIRTH(device(MessageTypeDBIRTH))
+	h.OnDDEATH(device(MessageTypeDDEATH))
+	h.OnDDATA(device(MessageTypeDDATA))
+
+	if err := h.Connect(); err != nil {
+		t.Fatalf("host Connect() error = %v", err)
+	}
+	t.Cleanup(func() { h.Disconnect() })
+
+	waitUntil(t, "the host is online", func() bool {
+		_, ok := retained(stateTopic(config.HostID))
+		return ok
+	})
+
+	return h, events
+}
+
+func newTestClient(t *testing.T, loop *Loopback, config Config) *Client {
+	t.Helper()
+
+	config.Host = "loopback"
+	config.Port = 1883
+	config.ClientID = "edge"
+	config.GroupID = "plant"
+	config.NodeID = "edge-1"
+	config.Transport = loop.Transport
+
+	return NewClient(config)
+}
+
+func connectTestClient(t *testing.T, c *Client) {
+	t.Helper()
+
+	if err := c.Connect(); err != nil {
+		t.Fatalf("client Connect() error = %v", err)
+	}
+	t.Cleanup(func() { c.Disconnect() })
+}
+
+func sniff(t *testing.T, loop *Loopback, filter string, buffer int) <-chan TransportMessage {
+	t.Helper()
+
+	messages := make(chan TransportMessage, buffer)
+	sniffer, err := loop.Transport(TransportConfig{ClientID: "sniffer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sniffer.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sniffer.Disconnect)
+
+	if err := sniffer.Subscribe(filter, 0, func(msg TransportMessage) { messages <- msg }); err != nil {
+		t.Fatal(err)
+	}
+
+	return messages
+}
+
+func waitForEvent(t *testing.T, events <-chan hostEvent, messageType string) hostEvent {
+	t.Helper()
+
+	timeout := time.After(loopbackTimeout)
+	for {
+		select {
+		case event := <-events:
+			if event.messageType == messageType {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", messageType)
+			return hostEvent{}
+		}
+	}
+}
+
+func expectNoEvent(t *testing.T, events <-chan hostEvent, messageType string, wait time.Duration) {
+	t.Helper()
+
+	timeout := time.After(wait)
+	for {
+		select {
+		case event := <-events:
+			if event.messageType == messageType {
+				t.Fatalf("received unexpected %s", messageType)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func waitUntil(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(loopbackTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func findMetric(payload *sproto.Payload, name string) (*sproto.Payload_Metric, bool) {
+	for _, metric := range payload.Metrics {
+		if metric.GetName() == name {
+			return metric, true
+		}
+	}
+
+	return nil, false
+}
+
+func publishCommand(t *testing.T, loop *Loopback, topic string, metrics ...*sproto.Payload_Metric) {
+	t.Helper()
+
+	data, err := proto.Marshal(&sproto.Payload{
+		Timestamp: proto.Uint64(uint64(time.Now().UnixMilli())),
+		Metrics:   metrics,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loop.Publish(topic, data, false)
+}
+
+func commandMetric(t *testing.T, name string, dataType sproto.DataType, value any) *sproto.Payload_Metric {
+	t.Helper()
+
+	metric, err := NewMetric(name, dataType, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return metric
+}
+
+func TestLoopbackNodeCommand(t *testing.T) {
+	loop := NewLoopback()
+	_, events := newLoopbackHost(t, loop)
+	c := newTestClient(t, loop, Config{})
+
+	if _, err := c.NodeMetrics().Declare(MetricDefinition{Name: "Setpoint", DataType: sproto.DataType_Double, Writable: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	commands := make(chan Command, 1)
+	c.HandleNodeCommand("Setpoint", CommandHandler{
+		Echo: true,
+		Handle: func(cmd Command) error {
+			commands <- cmd
+			return nil
+		},
+	})
+
+	connectTestClient(t, c)
+	waitForEvent(t, events, MessageTypeNBIRTH)
+
+	publishCommand(t, loop, "spBv1.0/plant/NCMD/edge-1", commandMetric(t, "Setpoint", sproto.DataType_Double, 42.5))
+
+	select {
+	case cmd := <-commands:
+		if cmd.DeviceID != "" || cmd.Value != 42.5 {
+			t.Errorf("command = %+v, want node Setpoint 42.5", cmd)
+		}
+	case <-time.After(loopbackTimeout):
+		t.Fatal("timed out waiting for the NCMD handler")
+	}
+
+	echo := waitForEvent(t, events, MessageTypeNDATA)
+	metric, ok := findMetric(echo.payload, "Setpoint")
+	if !ok || metric.GetDoubleValue() != 42.5 {
+		t.Errorf("NDATA echo = %v, want Setpoint 42.5", echo.payload.Metrics)
+	}
+}
+
+func TestLoopbackDeviceCommands(t *testing.T) {
+	loop := NewLoopback()
+	_, events := newLoopbackHost(t, loop)
+	c := newTestClient(t, loop, Config{})
+
+	pump := &testDevice{id: "pump", values: map[string]any{"setpoint": 10.0, "running": false}}
+	valve := &testDevice{id: "valve", values: map[string]any{"position": 0.0}}
+	c.DeviceMetrics("pump").Declare(MetricDefinition{Name: "setpoint", DataType: sproto.DataType_Double, Writable: true})
+	c.DeviceMetrics("valve").Declare(MetricDefinition{Name: "position", DataType: sproto.DataType_Double, Writable: true})
+
+	handled := make(chan Command, 1)
+	c.HandleDeviceCommand("pump", "setpoint", CommandHandler{
+		Echo: true,
+		Handle: func(cmd Command) error {
+			handled <- cmd
+			return nil
+		},
+	})
+
+	connectTestClient(t, c)
+	waitForEvent(t, events, MessageTypeNBIRTH)
+
+	for _, device := range []*testDevice{pump, valve} {
+		if err := c.AddDevice(device); err != nil {
+			t.Fatalf("AddDevice(%s) error = %v", device.id, err)
+		}
+		waitForEvent(t, events, MessageTypeDBIRTH)
+	}
+
+	t.Run("handler on writable metric", func(t *testing.T) {
+		publishCommand(t, loop, "spBv1.0/plant/DCMD/edge-1/pump", commandMetric(t, "setpoint", sproto.DataType_Double, 12.5))
+
+		select {
+		case cmd := <-handled:
+			if cmd.DeviceID != "pump" || cmd.Value != 12.5 {
+				t.Errorf("command = %+v, want pump setpoint 12.5", cmd)
+			}
+		case <-time.After(loopbackTimeout):
+			t.Fatal("timed out waiting for the DCMD handler")
+		}
+
+		echo := waitForEvent(t, events, MessageTypeDDATA)
+		if metric, ok := findMetric(echo.payload, "setpoint"); echo.deviceID != "pump" || !ok || metric.GetDoubleValue() != 12.5 {
+			t.Errorf("DDATA echo for %s = %v, want pump setpoint 12.5", echo.deviceID, echo.payload.Metrics)
+		}
+	})
+
+	t.Run("write through SetMetricValue", func(t *testing.T) {
+		publishCommand(t, loop, "spBv1.0/plant/DCMD/edge-1/valve", commandMetric(t, "position", sproto.DataType_Double, 0.75))
+
+		echo := waitForEvent(t, events, MessageTypeDDATA)
+		if metric, ok := findMetric(echo.payload, "position"); echo.deviceID != "valve" || !ok || metric.GetDoubleValue() != 0.75 {
+			t.Errorf("DDATA echo for %s = %v, want valve position 0.75", echo.deviceID, echo.payload.Metrics)
+		}
+		if got := valve.GetMetricValues()["position"]; got != 0.75 {
+			t.Errorf("valve position = %v, want 0.75", got)
+		}
+	})
+
+	t.Run("read-only metric", func(t *testing.T) {
+		publishCommand(t, loop, "spBv1.0/plant/DCMD/edge-1/pump", commandMetric(t, "running", sproto.DataType_Boolean, true))
+
+		waitUntil(t, "the write is rejected", func() bool { return c.RejectedWrites() == 1 })
+		if got := pump.GetMetricValues()["running"]; got != false {
+			t.Errorf("pump running = %v, want false", got)
+		}
+	})
+}
+
+func TestLoopbackCommandByAlias(t *testing.T) {
+	loop := NewLoopback()
+	_, events := newLoopbackHost(t, loop)
+	c := newTestClient(t, loop, Config{})
+	connectTestClient(t, c)
+	birth := waitForEvent(t, events, MessageTypeNBIRTH)
+
+	rebirth, ok := findMetric(birth.payload, "Node Control/Rebirth")
+	if !ok || rebirth.Alias == nil {
+		t.Fatal("NBIRTH has no aliased Node Control/Rebirth metric")
+	}
+
+	publishCommand(t, loop, "spBv1.0/plant/NCMD/edge-1", &sproto.Payload_Metric{
+		Alias: proto.Uint64(rebirth.GetAlias()),
+		Value: &sproto.Payload_Metric_BooleanValue{BooleanValue: true},
+	})
+
+	if got := waitForEvent(t, events, MessageTypeNBIRTH); got.payload.GetSeq() != 0 {
+		t.Errorf("rebirth NBIRTH seq = %d, want 0", got.payload.GetSeq())
+	}
+}
+
+func TestLoopbackHostResolvesAliases(t *testing.T) {
+	loop := NewLoopback()
+	_, events := newLoopbackHost(t, loop)
+	c := newTestClient(t, loop, Config{})
+
+	c.NodeMetrics().Declare(MetricDefinition{Name: "Temperature", DataType: sproto.DataType_Double})
+	meter := &testDevice{id: "meter", values: map[string]any{"energy": uint64(100)}}
+
+	connectTestClient(t, c)
+	birth := waitForEvent(t, events, MessageTypeNBIRTH)
+	if err := c.AddDevice(meter); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, events, MessageTypeDBIRTH)
+
+	if err := c.PublishNDATA(map[string]any{"Temperature": 21.5}); err != nil {
+		t.Fatal(err)
+	}
+	ndata := waitForEvent(t, events, MessageTypeNDATA)
+
+	declared, _ := findMetric(birth.payload, "Temperature")
+	metric, ok := findMetric(ndata.payload, "Temperature")
+	if !ok || metric.GetAlias() != declared.GetAlias() || metric.GetDoubleValue() != 21.5 {
+		t.Errorf("NDATA = %v, want Temperature 21.5 resolved from alias %d", ndata.payload.Metrics, declared.GetAlias())
+	}
+
+	if err := c.PublishDDATA(meter, map[string]any{"energy": uint64(101)}); err != nil {
+		t.Fatal(err)
+	}
+	ddata := waitForEvent(t, events, MessageTypeDDATA)
+	if metric, ok := findMetric(ddata.payload, "energy"); !ok || metric.GetLongValue() != 101 {
+		t.Errorf("DDATA = %v, want energy 101 resolved from its alias", ddata.payload.Metrics)
+	}
+}
+
+func TestLoopbackPrimaryHostGatesNBIRTH(t *testing.T) {
+	loop := NewLoopback()
+	_, events := newLoopbackHost(t, loop)
+	c := newTestClient(t, loop, Config{PrimaryHostID: "scada"})
+	connectTestClient(t, c)
+
+	expectNoEvent(t, events, MessageTypeNBIRTH, 100*time.Millisecond)
+	if c.IsPrimaryHostOnline() {
+		t.Fatal("primary host reported online before it connected")
+	}
+
+	primary := NewHostApplication(HostConfig{Host: "loopback", Port: 1883, ClientID: "scada", HostID: "scada", Transport: loop.Transport})
+	if err := primary.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForEvent(t, events, MessageTypeNBIRTH)
+	if !c.IsPrimaryHostOnline() {
+		t.Error("IsPrimaryHostOnline() = false after NBIRTH")
+	}
+
+	if err := primary.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, events, MessageTypeNDEATH)
+
+	if err := primary.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, events, MessageTypeNBIRTH)
+
+	if err := c.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoopbackNDATABeforeNBIRTH(t *testing.T) {
+	loop := NewLoopback()
+	messages := sniff(t, loop, "spBv1.0/plant/NDATA/#", 1)
+	c := newTestClient(t, loop, Config{PrimaryHostID: "scada"})
+	if _, err := c.NodeMetrics().Declare(MetricDefinition{Name: "Temperature", DataType: sproto.DataType_Double}); err != nil {
+		t.Fatal(err)
+	}
+	connectTestClient(t, c)
+
+	err := c.PublishNDATA(map[string]any{"Temperature": 21.5})
+
+	var notBorn *NodeNotBornError
+	if !errors.As(err, &notBorn) || notBorn.NodeID != "edge-1" {
+		t.Fatalf("PublishNDATA() error = %v, want *NodeNotBornError for edge-1", err)
+	}
+
+	select {
+	case msg := <-messages:
+		t.Errorf("NDATA published to %s before NBIRTH", msg.Topic)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -1,86 +1,541 @@
 package spb
 
 import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"reflect"
 	"time"
 
 	"github.com/tjeumaster/go-sparkplug/sproto"
 	"google.golang.org/protobuf/proto"
 )
 
-func ToMetric(name string, value any) *sproto.Payload_Metric {
-	var metricType sproto.DataType
-	var metricValue sproto.Payload_Metric_Value
+type Text string
 
-	switch v := value.(type) {
-	case int:
-		metricType = sproto.DataType_Int64
-		metricValue = &sproto.Payload_Metric_LongValue{
-			LongValue: uint64(v),
-		}
+type UUID [16]byte
+
+func (u UUID) String() string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
+
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, fmt.Errorf("invalid UUID %q", s)
+	}
+
+	digits := s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:]
+	if _, err := hex.Decode(u[:], []byte(digits)); err != nil {
+		return u, fmt.Errorf("invalid UUID %q: %w", s, err)
+	}
+
+	return u, nil
+}
+
+type File []byte
+
+type UnsupportedValueError struct {
+	Name  string
+	Value any
+}
+
+func (e *UnsupportedValueError) Error() string {
+	return fmt.Sprintf("unsupported value of type %T for metric %s", e.Value, e.Name)
+}
+
+type MetricTypeError struct {
+	Name     string
+	DataType sproto.DataType
+	Value    any
+}
+
+func (e *MetricTypeError) Error() string {
+	return fmt.Sprintf("value of type %T is not valid for metric %s with datatype %s", e.Value, e.Name, e.DataType)
+}
+
+func ToMetric(name string, value any) (*sproto.Payload_Metric, error) {
+	dataType, ok := inferDataType(value)
+	if !ok {
+		return nil, &UnsupportedValueError{Name: name, Value: value}
+	}
+
+	return NewMetric(name, dataType, value)
+}
+
+func NewMetric(name string, dataType sproto.DataType, value any) (*sproto.Payload_Metric, error) {
+	metricValue, ok := encodeValue(dataType, value)
+	if !ok {
+		return nil, &MetricTypeError{Name: name, DataType: dataType, Value: value}
+	}
+
+	metric := &sproto.Payload_Metric{
+		Name:      proto.String(name),
+		Timestamp: proto.Uint64(uint64(time.Now().UnixMilli())),
+		Datatype:  proto.Uint32(uint32(dataType)),
+		Value:     metricValue,
+	}
+
+	return metric, nil
+}
+
+func newNullMetric(name string, dataType sproto.DataType) *sproto.Payload_Metric {
+	return &sproto.Payload_Metric{
+		Name:      proto.String(name),
+		Timestamp: proto.Uint64(uint64(time.Now().UnixMilli())),
+		Datatype:  proto.Uint32(uint32(dataType)),
+		IsNull:    proto.Bool(true),
+	}
+}
 
+func inferDataType(value any) (sproto.DataType, bool) {
+	switch value.(type) {
+	case int8:
+		return sproto.DataType_Int8, true
+	case int16:
+		return sproto.DataType_Int16, true
 	case int32:
-		metricType = sproto.DataType_Int32
-		metricValue = &sproto.Payload_Metric_IntValue{
-			IntValue: uint32(v),
+		return sproto.DataType_Int32, true
+	case int, int64:
+		return sproto.DataType_Int64, true
+	case uint8:
+		return sproto.DataType_UInt8, true
+	case uint16:
+		return sproto.DataType_UInt16, true
+	case uint32:
+		return sproto.DataType_UInt32, true
+	case uint, uint64:
+		return sproto.DataType_UInt64, true
+	case float32:
+		return sproto.DataType_Float, true
+	case float64:
+		return sproto.DataType_Double, true
+	case bool:
+		return sproto.DataType_Boolean, true
+	case string:
+		return sproto.DataType_String, true
+	case time.Time:
+		return sproto.DataType_DateTime, true
+	case Text:
+		return sproto.DataType_Text, true
+	case UUID:
+		return sproto.DataType_UUID, true
+	case []byte:
+		return sproto.DataType_Bytes, true
+	case File:
+		return sproto.DataType_File, true
+	case *DataSet:
+		return sproto.DataType_DataSet, true
+	case *Template:
+		return sproto.DataType_Template, true
+	}
+
+	if _, ok := uuidString(value); ok {
+		return sproto.DataType_UUID, true
+	}
+
+	return inferArrayDataType(value)
+}
+
+func encodeValue(dataType sproto.DataType, value any) (sproto.Payload_Metric_Value, bool) {
+	switch dataType {
+	case sproto.DataType_Int8, sproto.DataType_Int16, sproto.DataType_Int32:
+		v, ok := toInt64(value)
+		if !ok || v < intMin(dataType) || v > intMax(dataType) {
+			return nil, false
 		}
-	case int64:
-		metricType = sproto.DataType_Int64
-		metricValue = &sproto.Payload_Metric_LongValue{
-			LongValue: uint64(v),
+		switch dataType {
+		case sproto.DataType_Int8:
+			return &sproto.Payload_Metric_IntValue{IntValue: uint32(uint8(v))}, true
+		case sproto.DataType_Int16:
+			return &sproto.Payload_Metric_IntValue{IntValue: uint32(uint16(v))}, true
 		}
+		return &sproto.Payload_Metric_IntValue{IntValue: uint32(int32(v))}, true
 
-	case uint32:
-		metricType = sproto.DataType_UInt32
-		metricValue = &sproto.Payload_Metric_IntValue{
-			IntValue: uint32(v),
+	case sproto.DataType_Int64:
+		v, ok := toInt64(value)
+		if !ok {
+			return nil, false
 		}
+		return &sproto.Payload_Metric_LongValue{LongValue: uint64(v)}, true
 
-	case uint64:
-		metricType = sproto.DataType_UInt64
-		metricValue = &sproto.Payload_Metric_LongValue{
-			LongValue: uint64(v),
+	case sproto.DataType_UInt8, sproto.DataType_UInt16, sproto.DataType_UInt32:
+		v, ok := toUint64(value)
+		if !ok || v > uintMax(dataType) {
+			return nil, false
 		}
+		return &sproto.Payload_Metric_IntValue{IntValue: uint32(v)}, true
 
-	case float32:
-		metricType = sproto.DataType_Float
-		metricValue = &sproto.Payload_Metric_FloatValue{
-			FloatValue: float32(v),
+	case sproto.DataType_UInt64:
+		v, ok := toUint64(value)
+		if !ok {
+			return nil, false
 		}
+		return &sproto.Payload_Metric_LongValue{LongValue: v}, true
 
-	case float64:
-		metricType = sproto.DataType_Double
-		metricValue = &sproto.Payload_Metric_DoubleValue{
-			DoubleValue: float64(v),
+	case sproto.DataType_Float:
+		v, ok := value.(float32)
+		if !ok {
+			return nil, false
+		}
+		return &sproto.Payload_Metric_FloatValue{FloatValue: v}, true
+
+	case sproto.DataType_Double:
+		switch v := value.(type) {
+		case float32:
+			return &sproto.Payload_Metric_DoubleValue{DoubleValue: float64(v)}, true
+		case float64:
+			return &sproto.Payload_Metric_DoubleValue{DoubleValue: v}, true
+		}
+		return nil, false
+
+	case sproto.DataType_Boolean:
+		v, ok := value.(bool)
+		if !ok {
+			return nil, false
+		}
+		return &sproto.Payload_Metric_BooleanValue{BooleanValue: v}, true
+
+	case sproto.DataType_String:
+		v, ok := value.(string)
+		if !ok {
+			return nil, false
+		}
+		return &sproto.Payload_Metric_StringValue{StringValue: v}, true
+
+	case sproto.DataType_Text:
+		switch v := value.(type) {
+		case Text:
+			return &sproto.Payload_Metric_StringValue{StringValue: string(v)}, true
+		case string:
+			return &sproto.Payload_Metric_StringValue{StringValue: v}, true
+		}
+		return nil, false
+
+	case sproto.DataType_UUID:
+		v, ok := uuidString(value)
+		if !ok {
+			return nil, false
+		}
+		return &sproto.Payload_Metric_StringValue{StringValue: v}, true
+
+	case sproto.DataType_DateTime:
+		v, ok := value.(time.Time)
+		if !ok {
+			return nil, false
+		}
+		return &sproto.Payload_Metric_LongValue{LongValue: uint64(v.UnixMilli())}, true
+
+	case sproto.DataType_Bytes, sproto.DataType_File:
+		switch v := value.(type) {
+		case []byte:
+			return &sproto.Payload_Metric_BytesValue{BytesValue: v}, true
+		case File:
+			return &sproto.Payload_Metric_BytesValue{BytesValue: []byte(v)}, true
+		}
+		return nil, false
+
+	case sproto.DataType_DataSet:
+		v, ok := value.(*DataSet)
+		if !ok || v == nil {
+			return nil, false
+		}
+		dataset, err := v.toProto()
+		if err != nil {
+			return nil, false
+		}
+		return &sproto.Payload_Metric_DatasetValue{DatasetValue: dataset}, true
+
+	case sproto.DataType_Template:
+		v, ok := value.(*Template)
+		if !ok || v == nil {
+			return nil, false
+		}
+		template, err := v.toProto()
+		if err != nil {
+			return nil, false
+		}
+		return &sproto.Payload_Metric_TemplateValue{TemplateValue: template}, true
+
+	default:
+		if !isArrayDataType(dataType) {
+			return nil, false
+		}
+		v, ok := encodeArray(dataType, value)
+		if !ok {
+			return nil, false
+		}
+		return &sproto.Payload_Metric_BytesValue{BytesValue: v}, true
+	}
+}
+
+func MetricValue(metric *sproto.Payload_Metric) (any, error) {
+	if metric.GetIsNull() {
+		return nil, nil
+	}
+
+	return typedMetricValue(sproto.DataType(metric.GetDatatype()), metric)
+}
+
+func typedMetricValue(dataType sproto.DataType, metric *sproto.Payload_Metric) (any, error) {
+	if !valueMatchesDataType(dataType, metric.GetValue()) {
+		return nil, &MetricTypeError{Name: metric.GetName(), DataType: dataType, Value: metric.GetValue()}
+	}
+
+	value, err := decodeMetricValue(dataType, metric)
+	if err != nil {
+		return nil, &MetricDecodeError{Name: metric.GetName(), DataType: dataType, Err: err}
+	}
+
+	return value, nil
+}
+
+func decodeMetricValue(dataType sproto.DataType, metric *sproto.Payload_Metric) (any, error) {
+	switch dataType {
+	case sproto.DataType_Int8:
+		v, err := decodeSignedInt(dataType, metric.GetIntValue())
+		return int8(v), err
+	case sproto.DataType_Int16:
+		v, err := decodeSignedInt(dataType, metric.GetIntValue())
+		return int16(v), err
+	case sproto.DataType_Int32:
+		return int32(metric.GetIntValue()), nil
+	case sproto.DataType_Int64:
+		return int64(metric.GetLongValue()), nil
+	case sproto.DataType_UInt8, sproto.DataType_UInt16:
+		v := metric.GetIntValue()
+		if uint64(v) > uintMax(dataType) {
+			return nil, fmt.Errorf("value %d is out of range", v)
+		}
+		if dataType == sproto.DataType_UInt8 {
+			return uint8(v), nil
 		}
+		return uint16(v), nil
+	case sproto.DataType_UInt32:
+		return metric.GetIntValue(), nil
+	case sproto.DataType_UInt64:
+		return metric.GetLongValue(), nil
+	case sproto.DataType_Float:
+		return metric.GetFloatValue(), nil
+	case sproto.DataType_Double:
+		return metric.GetDoubleValue(), nil
+	case sproto.DataType_Boolean:
+		return metric.GetBooleanValue(), nil
+	case sproto.DataType_String:
+		return metric.GetStringValue(), nil
+	case sproto.DataType_Text:
+		return Text(metric.GetStringValue()), nil
+	case sproto.DataType_UUID:
+		return ParseUUID(metric.GetStringValue())
+	case sproto.DataType_DateTime:
+		return time.UnixMilli(int64(metric.GetLongValue())).UTC(), nil
+	case sproto.DataType_Bytes:
+		return metric.GetBytesValue(), nil
+	case sproto.DataType_File:
+		return File(metric.GetBytesValue()), nil
+	case sproto.DataType_DataSet:
+		return DecodeDataSet(metric.GetDatasetValue())
+	case sproto.DataType_Template:
+		return DecodeTemplate(metric.GetTemplateValue())
+	}
 
+	if isArrayDataType(dataType) {
+		return decodeArray(dataType, metric.GetBytesValue())
+	}
+
+	return nil, fmt.Errorf("unsupported datatype %s", dataType)
+}
+
+func decodeSignedInt(dataType sproto.DataType, raw uint32) (int64, error) {
+	if v := int64(int32(raw)); v >= intMin(dataType) && v <= intMax(dataType) {
+		return v, nil
+	}
+
+	span := 2 * (intMax(dataType) + 1)
+	if int64(raw) < span {
+		return int64(raw) - span, nil
+	}
+
+	return 0, fmt.Errorf("value %d is out of range", raw)
+}
+
+func valueMatchesDataType(dataType sproto.DataType, value sproto.Payload_Metric_Value) bool {
+	switch dataType {
+	case sproto.DataType_Int8, sproto.DataType_Int16, sproto.DataType_Int32,
+		sproto.DataType_UInt8, sproto.DataType_UInt16, sproto.DataType_UInt32:
+		_, ok := value.(*sproto.Payload_Metric_IntValue)
+		return ok
+	case sproto.DataType_Int64, sproto.DataType_UInt64, sproto.DataType_DateTime:
+		_, ok := value.(*sproto.Payload_Metric_LongValue)
+		return ok
+	case sproto.DataType_Float:
+		_, ok := value.(*sproto.Payload_Metric_FloatValue)
+		return ok
+	case sproto.DataType_Double:
+		_, ok := value.(*sproto.Payload_Metric_DoubleValue)
+		return ok
+	case sproto.DataType_Boolean:
+		_, ok := value.(*sproto.Payload_Metric_BooleanValue)
+		return ok
+	case sproto.DataType_String, sproto.DataType_Text, sproto.DataType_UUID:
+		_, ok := value.(*sproto.Payload_Metric_StringValue)
+		return ok
+	case sproto.DataType_Bytes, sproto.DataType_File:
+		_, ok := value.(*sproto.Payload_Metric_BytesValue)
+		return ok
+	case sproto.DataType_DataSet:
+		_, ok := value.(*sproto.Payload_Metric_DatasetValue)
+		return ok
+	case sproto.DataType_Template:
+		_, ok := value.(*sproto.Payload_Metric_TemplateValue)
+		return ok
+	default:
+		_, ok := value.(*sproto.Payload_Metric_BytesValue)
+		return ok && isArrayDataType(dataType)
+	}
+}
+
+func uuidString(value any) (string, bool) {
+	switch v := value.(type) {
+	case UUID:
+		return v.String(), true
 	case string:
-		metricType = sproto.DataType_String
-		metricValue = &sproto.Payload_Metric_StringValue{
-			StringValue: string(v),
+		if _, err := ParseUUID(v); err != nil {
+			return "", false
 		}
+		return v, true
+	case nil:
+		return "", false
+	}
 
-	case bool:
-		metricType = sproto.DataType_Boolean
-		metricValue = &sproto.Payload_Metric_BooleanValue{
-			BooleanValue: bool(v),
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Array || rv.Len() != 16 || rv.Type().Elem().Kind() != reflect.Uint8 {
+		return "", false
+	}
+
+	var u UUID
+	reflect.Copy(reflect.ValueOf(u[:]), rv)
+	return u.String(), true
+}
+
+func toInt64(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint:
+		if uint64(v) > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, false
 		}
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
 
-	case []byte:
-		metricType = sproto.DataType_Bytes
-		metricValue = &sproto.Payload_Metric_BytesValue{
-			BytesValue: v,
+func toUint64(value any) (uint64, bool) {
+	switch v := value.(type) {
+	case uint:
+		return uint64(v), true
+	case uint8:
+		return uint64(v), true
+	case uint16:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	default:
+		i, ok := toInt64(value)
+		if !ok || i < 0 {
+			return 0, false
 		}
+		return uint64(i), true
+	}
+}
+
+func toFloat64(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+
+	if i, ok := toInt64(value); ok {
+		return float64(i), true
+	}
+
+	if u, ok := toUint64(value); ok {
+		return float64(u), true
+	}
+
+	return 0, false
+}
 
+func intMin(dataType sproto.DataType) int64 {
+	switch dataType {
+	case sproto.DataType_Int8:
+		return math.MinInt8
+	case sproto.DataType_Int16:
+		return math.MinInt16
+	case sproto.DataType_Int32:
+		return math.MinInt32
 	default:
-		return nil
+		return math.MinInt64
 	}
+}
 
-	metric := &sproto.Payload_Metric{
-		Name:      proto.String(name),
-		Timestamp: proto.Uint64(uint64(time.Now().UnixMilli())),
-		Datatype:  proto.Uint32(uint32(metricType)),
-		Value:     metricValue,
+func intMax(dataType sproto.DataType) int64 {
+	switch dataType {
+	case sproto.DataType_Int8:
+		return math.MaxInt8
+	case sproto.DataType_Int16:
+		return math.MaxInt16
+	case sproto.DataType_Int32:
+		return math.MaxInt32
+	default:
+		return math.MaxInt64
 	}
+}
 
-	return metric
+func uintMax(dataType sproto.DataType) uint64 {
+	switch dataType {
+	case sproto.DataType_UInt8:
+		return math.MaxUint8
+	case sproto.DataType_UInt16:
+		return math.MaxUint16
+	case sproto.DataType_UInt32:
+		return math.MaxUint32
+	default:
+		return math.MaxUint64
+	}
 }
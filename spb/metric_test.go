@@ -0,0 +1,196 @@
+package spb
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+)
+
+func TestToMetricEncoding(t *testing.T) {
+	uuid, err := ParseUUID("123e4567-e89b-12d3-a456-426614174000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		value    any
+		dataType sproto.DataType
+		want     sproto.Payload_Metric_Value
+	}{
+		{"int8", int8(100), sproto.DataType_Int8, &sproto.Payload_Metric_IntValue{IntValue: 100}},
+		{"int16", int16(30000), sproto.DataType_Int16, &sproto.Payload_Metric_IntValue{IntValue: 30000}},
+		{"int32", int32(123456), sproto.DataType_Int32, &sproto.Payload_Metric_IntValue{IntValue: 123456}},
+		{"int64", int64(1 << 40), sproto.DataType_Int64, &sproto.Payload_Metric_LongValue{LongValue: 1 << 40}},
+		{"int", 42, sproto.DataType_Int64, &sproto.Payload_Metric_LongValue{LongValue: 42}},
+		{"uint8", uint8(math.MaxUint8), sproto.DataType_UInt8, &sproto.Payload_Metric_IntValue{IntValue: math.MaxUint8}},
+		{"uint16", uint16(math.MaxUint16), sproto.DataType_UInt16, &sproto.Payload_Metric_IntValue{IntValue: math.MaxUint16}},
+		{"uint32", uint32(math.MaxUint32), sproto.DataType_UInt32, &sproto.Payload_Metric_IntValue{IntValue: math.MaxUint32}},
+		{"uint64", uint64(math.MaxUint64), sproto.DataType_UInt64, &sproto.Payload_Metric_LongValue{LongValue: math.MaxUint64}},
+		{"uint", uint(7), sproto.DataType_UInt64, &sproto.Payload_Metric_LongValue{LongValue: 7}},
+		{"float", float32(1.5), sproto.DataType_Float, &sproto.Payload_Metric_FloatValue{FloatValue: 1.5}},
+		{"double", -2.25, sproto.DataType_Double, &sproto.Payload_Metric_DoubleValue{DoubleValue: -2.25}},
+		{"boolean", true, sproto.DataType_Boolean, &sproto.Payload_Metric_BooleanValue{BooleanValue: true}},
+		{"string", "hello", sproto.DataType_String, &sproto.Payload_Metric_StringValue{StringValue: "hello"}},
+		{"text", Text("long text"), sproto.DataType_Text, &sproto.Payload_Metric_StringValue{StringValue: "long text"}},
+		{"uuid", uuid, sproto.DataType_UUID, &sproto.Payload_Metric_StringValue{StringValue: "123e4567-e89b-12d3-a456-426614174000"}},
+		{"datetime", time.UnixMilli(1700000000123), sproto.DataType_DateTime, &sproto.Payload_Metric_LongValue{LongValue: 1700000000123}},
+		{"bytes", []byte{1, 2, 3}, sproto.DataType_Bytes, &sproto.Payload_Metric_BytesValue{BytesValue: []byte{1, 2, 3}}},
+		{"file", File{4, 5}, sproto.DataType_File, &sproto.Payload_Metric_BytesValue{BytesValue: []byte{4, 5}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metric, err := ToMetric(tt.name, tt.value)
+			if err != nil {
+				t.Fatalf("ToMetric() error = %v", err)
+			}
+
+			if got := sproto.DataType(metric.GetDatatype()); got != tt.dataType {
+				t.Fatalf("datatype = %s, want %s", got, tt.dataType)
+			}
+
+			if !reflect.DeepEqual(metric.Value, tt.want) {
+				t.Errorf("value = %#v, want %#v", metric.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestToMetricRoundTrip(t *testing.T) {
+	uuid, err := ParseUUID("123e4567-e89b-12d3-a456-426614174000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		value    any
+		dataType sproto.DataType
+		want     any
+	}{
+		{"int8 min", int8(math.MinInt8), sproto.DataType_Int8, int8(math.MinInt8)},
+		{"int8 negative", int8(-1), sproto.DataType_Int8, int8(-1)},
+		{"int8 max", int8(math.MaxInt8), sproto.DataType_Int8, int8(math.MaxInt8)},
+		{"int16 min", int16(math.MinInt16), sproto.DataType_Int16, int16(math.MinInt16)},
+		{"int16 max", int16(math.MaxInt16), sproto.DataType_Int16, int16(math.MaxInt16)},
+		{"int32 min", int32(math.MinInt32), sproto.DataType_Int32, int32(math.MinInt32)},
+		{"int32 max", int32(math.MaxInt32), sproto.DataType_Int32, int32(math.MaxInt32)},
+		{"int64 min", int64(math.MinInt64), sproto.DataType_Int64, int64(math.MinInt64)},
+		{"int64 max", int64(math.MaxInt64), sproto.DataType_Int64, int64(math.MaxInt64)},
+		{"int", -42, sproto.DataType_Int64, int64(-42)},
+		{"uint8", uint8(math.MaxUint8), sproto.DataType_UInt8, uint8(math.MaxUint8)},
+		{"uint16", uint16(math.MaxUint16), sproto.DataType_UInt16, uint16(math.MaxUint16)},
+		{"uint32", uint32(math.MaxUint32), sproto.DataType_UInt32, uint32(math.MaxUint32)},
+		{"uint64", uint64(math.MaxUint64), sproto.DataType_UInt64, uint64(math.MaxUint64)},
+		{"uint", uint(7), sproto.DataType_UInt64, uint64(7)},
+		{"float", float32(1.5), sproto.DataType_Float, float32(1.5)},
+		{"double", -2.25, sproto.DataType_Double, -2.25},
+		{"boolean", true, sproto.DataType_Boolean, true},
+		{"string", "hello", sproto.DataType_String, "hello"},
+		{"text", Text("long text"), sproto.DataType_Text, Text("long text")},
+		{"uuid", uuid, sproto.DataType_UUID, uuid},
+		{"datetime", time.UnixMilli(1700000000123).UTC(), sproto.DataType_DateTime, time.UnixMilli(1700000000123).UTC()},
+		{"bytes", []byte{1, 2, 3}, sproto.DataType_Bytes, []byte{1, 2, 3}},
+		{"file", File{4, 5}, sproto.DataType_File, File{4, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metric, err := ToMetric(tt.name, tt.value)
+			if err != nil {
+				t.Fatalf("ToMetric() error = %v", err)
+			}
+
+			if got := sproto.DataType(metric.GetDatatype()); got != tt.dataType {
+				t.Fatalf("datatype = %s, want %s", got, tt.dataType)
+			}
+
+			got, err := MetricValue(metric)
+			if err != nil {
+				t.Fatalf("MetricValue() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MetricValue() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToMetricTwosComplement(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		int   uint32
+		long  uint64
+	}{
+		{"int8 -1", int8(-1), 0xFF, 0},
+		{"int8 -23", int8(-23), 233, 0},
+		{"int8 min", int8(math.MinInt8), 0x80, 0},
+		{"int16 -2", int16(-2), 0xFFFE, 0},
+		{"int32 min", int32(math.MinInt32), 0x80000000, 0},
+		{"int64 -1", int64(-1), 0, 0xFFFFFFFFFFFFFFFF},
+		{"int64 min", int64(math.MinInt64), 0, 0x8000000000000000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metric, err := ToMetric(tt.name, tt.value)
+			if err != nil {
+				t.Fatalf("ToMetric() error = %v", err)
+			}
+
+			if got := metric.GetIntValue(); got != tt.int {
+				t.Errorf("int_value = %#x, want %#x", got, tt.int)
+			}
+			if got := metric.GetLongValue(); got != tt.long {
+				t.Errorf("long_value = %#x, want %#x", got, tt.long)
+			}
+		})
+	}
+}
+
+func TestToMetricUnsupportedValue(t *testing.T) {
+	_, err := ToMetric("complex", complex(1, 2))
+
+	var unsupported *UnsupportedValueError
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("ToMetric() error = %v, want *UnsupportedValueError", err)
+	}
+	if unsupported.Name != "complex" {
+		t.Errorf("Name = %q, want %q", unsupported.Name, "complex")
+	}
+}
+
+func TestNewMetricOutOfRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		dataType sproto.DataType
+		value    any
+	}{
+		{"int8 overflow", sproto.DataType_Int8, 128},
+		{"int16 underflow", sproto.DataType_Int16, math.MinInt16 - 1},
+		{"uint8 negative", sproto.DataType_UInt8, -1},
+		{"uint16 overflow", sproto.DataType_UInt16, math.MaxUint16 + 1},
+		{"string as int32", sproto.DataType_Int32, "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMetric(tt.name, tt.dataType, tt.value)
+
+			var typeErr *MetricTypeError
+			if !errors.As(err, &typeErr) {
+				t.Fatalf("NewMetric() error = %v, want *MetricTypeError", err)
+			}
+			if typeErr.DataType != tt.dataType {
+				t.Errorf("DataType = %s, want %s", typeErr.DataType, tt.dataType)
+			}
+		})
+	}
+}
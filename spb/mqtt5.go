@@ -0,0 +1,365 @@
+package spb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+const (
+	mqtt5Timeout      = 10 * time.Second
+	mqtt5ErrorTimeout = 100 * time.Millisecond
+)
+
+type mqtt5Transport struct {
+	config    TransportConfig
+	serverURL *url.URL
+	router    *paho.StandardRouter
+	connected atomic.Bool
+
+	mu         sync.Mutex
+	manager    *autopaho.ConnectionManager
+	cancel     context.CancelFunc
+	lastError  error
+	errorSet   chan struct{}
+	connectErr chan error
+}
+
+func newMQTT5Transport(config TransportConfig) (*mqtt5Transport, error) {
+	mqttBroker, err := config.Broker.url()
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker configuration: %w", err)
+	}
+
+	serverURL, err := url.Parse(mqttBroker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker URL %s: %w", mqttBroker, err)
+	}
+
+	return &mqtt5Transport{
+		config:    config,
+		serverURL: serverURL,
+		router:    paho.NewStandardRouter(),
+		errorSet:  make(chan struct{}, 1),
+	}, nil
+}
+
+func (t *mqtt5Transport) Connect() error {
+	clientConfig := autopaho.ClientConfig{
+		ServerUrls:                    []*url.URL{t.serverURL},
+		KeepAlive:                     30,
+		CleanStartOnInitialConnection: t.config.SessionExpiry == 0,
+		SessionExpiryInterval:         uint32(t.config.SessionExpiry / time.Second),
+		ConnectRetryDelay:             5 * time.Second,
+		ConnectTimeout:                mqtt5Timeout,
+		ConnectPacketBuilder:          t.buildConnectPacket,
+		OnConnectionUp:                t.onConnectionUp,
+		OnConnectionDown:              t.onConnectionDown,
+		OnConnectError:                t.onConnectError,
+		ClientConfig: paho.ClientConfig{
+			ClientID: t.config.ClientID,
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){
+				func(received paho.PublishReceived) (bool, error) {
+					t.router.Route(received.Packet.Packet())
+					return true, nil
+				},
+			},
+			OnServerDisconnect: t.onServerDisconnect,
+			OnClientError:      t.setLastError,
+		},
+	}
+	clientConfig.SetUsernamePassword(t.config.Broker.Username, []byte(t.config.Broker.Password))
+
+	if tlsConfig := t.config.Broker.TLS; tlsConfig != nil {
+		var err error
+		if tlsConfig.ReloadCerts {
+			clientConfig.TlsCfg, err = tlsConfig.buildReloading(t.serverURL.Hostname())
+		} else {
+			clientConfig.TlsCfg, err = tlsConfig.build()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to configure broker connection: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	connectErr := make(chan error, 1)
+
+	t.mu.Lock()
+	t.cancel = cancel
+	t.connectErr = connectErr
+	t.mu.Unlock()
+
+	manager, err := autopaho.NewConnection(ctx, clientConfig)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("failed to connect to MQTT broker: %w", err)
+	}
+
+	t.mu.Lock()
+	t.manager = manager
+	t.mu.Unlock()
+
+	if err := manager.AwaitConnection(ctx); err != nil {
+		select {
+		case err = <-connectErr:
+		default:
+		}
+		return fmt.Errorf("failed to connect to MQTT broker: %w", err)
+	}
+
+	return nil
+}
+
+func (t *mqtt5Transport) buildConnectPacket(connect *paho.Connect, serverURL *url.URL) (*paho.Connect, error) {
+	if connect.Properties == nil {
+		connect.Properties = &paho.ConnectProperties{}
+	}
+	connect.Properties.RequestProblemInfo = true
+
+	if t.config.Will == nil {
+		return connect, nil
+	}
+
+	will, err := t.config.Will()
+	if err != nil {
+		return nil, err
+	}
+
+	if will != nil {
+		connect.WillMessage = &paho.WillMessage{
+			Topic:   will.Topic,
+			Payload: will.Payload,
+			QoS:     will.QoS,
+			Retain:  will.Retained,
+		}
+		connect.WillProperties = &paho.WillProperties{}
+	}
+
+	return connect, nil
+}
+
+func (t *mqtt5Transport) onConnectionUp(manager *autopaho.ConnectionManager, connack *paho.Connack) {
+	t.connected.Store(true)
+	t.setLastError(nil)
+
+	if t.config.OnConnect != nil {
+		go t.config.OnConnect()
+	}
+}
+
+func (t *mqtt5Transport) onConnectionDown() bool {
+	t.connected.Store(false)
+
+	if t.config.OnConnectionLost != nil {
+		go t.reportConnectionLost()
+	}
+
+	return t.config.AutoReconnect
+}
+
+func (t *mqtt5Transport) reportConnectionLost() {
+	select {
+	case <-t.errorSet:
+	case <-time.After(mqtt5ErrorTimeout):
+	}
+
+	t.mu.Lock()
+	err := t.lastError
+	t.mu.Unlock()
+	if err == nil {
+		err = errors.New("connection to MQTT broker lost")
+	}
+
+	t.config.OnConnectionLost(err)
+}
+
+func (t *mqtt5Transport) onConnectError(err error) {
+	var connackErr *autopaho.ConnackError
+	if errors.As(err, &connackErr) {
+		err = &ReasonCodeError{Packet: "CONNACK", Code: connackErr.ReasonCode, Reason: connackErr.Reason}
+	}
+
+	if t.config.AutoReconnect {
+		log.Printf("Failed to connect to MQTT broker %s: %v", t.serverURL, err)
+		return
+	}
+
+	t.mu.Lock()
+	connectErr, cancel := t.connectErr, t.cancel
+	t.mu.Unlock()
+
+	select {
+	case connectErr <- err:
+	default:
+	}
+	cancel()
+}
+
+func (t *mqtt5Transport) onServerDisconnect(disconnect *paho.Disconnect) {
+	var reason string
+	if disconnect.Properties != nil {
+		reason = disconnect.Properties.ReasonString
+	}
+
+	t.setLastError(&ReasonCodeError{Packet: "DISCONNECT", Code: disconnect.ReasonCode, Reason: reason})
+}
+
+func (t *mqtt5Transport) setLastError(err error) {
+	t.mu.Lock()
+	t.lastError = err
+	t.mu.Unlock()
+
+	if err == nil {
+		select {
+		case <-t.errorSet:
+		default:
+		}
+		return
+	}
+
+	select {
+	case t.errorSet <- struct{}{}:
+	default:
+	}
+}
+
+func (t *mqtt5Transport) Disconnect() {
+	t.mu.Lock()
+	manager, cancel := t.manager, t.cancel
+	t.mu.Unlock()
+
+	if manager != nil {
+		ctx, done := context.WithTimeout(context.Background(), time.Second)
+		defer done()
+		if err := manager.Disconnect(ctx); err != nil {
+			log.Printf("Failed to disconnect cleanly from MQTT broker: %v", err)
+		}
+	}
+
+	if cancel != nil {
+		cancel()
+	}
+
+	t.connected.Store(false)
+}
+
+func (t *mqtt5Transport) IsConnected() bool {
+	return t.connected.Load()
+}
+
+func (t *mqtt5Transport) Publish(topic string, qos byte, retained bool, payload []byte, options PublishOptions) error {
+	t.mu.Lock()
+	manager := t.manager
+	t.mu.Unlock()
+	if manager == nil {
+		return fmt.Errorf("MQTT client is not connected")
+	}
+
+	publish := &paho.Publish{
+		Topic:   topic,
+		QoS:     qos,
+		Retain:  retained,
+		Payload: payload,
+	}
+
+	if options.MessageExpiry > 0 || len(options.UserProperties) > 0 {
+		publish.Properties = &paho.PublishProperties{
+			User: toUserProperties(options.UserProperties),
+		}
+
+		if options.MessageExpiry > 0 {
+			expiry := uint32((options.MessageExpiry + time.Second - 1) / time.Second)
+			publish.Properties.MessageExpiry = &expiry
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mqtt5Timeout)
+	defer cancel()
+
+	response, err := manager.Publish(ctx, publish)
+	if response != nil && response.ReasonCode >= 0x80 {
+		var reason string
+		if response.Properties != nil {
+			reason = response.Properties.ReasonString
+		}
+		return &ReasonCodeError{Packet: "PUBACK", Code: response.ReasonCode, Reason: reason}
+	}
+
+	return err
+}
+
+func (t *mqtt5Transport) Subscribe(topic string, qos byte, handler func(msg TransportMessage)) error {
+	t.mu.Lock()
+	manager := t.manager
+	t.mu.Unlock()
+	if manager == nil {
+		return fmt.Errorf("MQTT client is not connected")
+	}
+
+	t.router.UnregisterHandler(topic)
+	t.router.RegisterHandler(topic, func(publish *paho.Publish) {
+		msg := TransportMessage{Topic: publish.Topic, Payload: publish.Payload}
+		if publish.Properties != nil {
+			msg.UserProperties = fromUserProperties(publish.Properties.User)
+		}
+		handler(msg)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), mqtt5Timeout)
+	defer cancel()
+
+	suback, err := manager.Subscribe(ctx, &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: topic, QoS: qos}},
+	})
+	if suback != nil {
+		for _, code := range suback.Reasons {
+			if code >= 0x80 {
+				var reason string
+				if suback.Properties != nil {
+					reason = suback.Properties.ReasonString
+				}
+				return &ReasonCodeError{Packet: "SUBACK", Code: code, Reason: reason}
+			}
+		}
+	}
+
+	return err
+}
+
+func toUserProperties(properties map[string]string) paho.UserProperties {
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	user := make(paho.UserProperties, 0, len(keys))
+	for _, key := range keys {
+		user.Add(key, properties[key])
+	}
+
+	return user
+}
+
+func fromUserProperties(user paho.UserProperties) map[string]string {
+	if len(user) == 0 {
+		return nil
+	}
+
+	properties := make(map[string]string, len(user))
+	for _, property := range user {
+		properties[property.Key] = property.Value
+	}
+
+	return properties
+}
@@ -0,0 +1,353 @@
+package spb
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/tjeumaster/go-sparkplug/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+type mqtt5Broker struct {
+	listener net.Listener
+	connack  byte
+	puback   byte
+	suback   byte
+	reason   string
+
+	connects  chan *packets.Connect
+	publishes chan *packets.Publish
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newMQTT5Broker(t *testing.T, connack, puback, suback byte) *mqtt5Broker {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &mqtt5Broker{
+		listener:  listener,
+		connack:   connack,
+		puback:    puback,
+		suback:    suback,
+		reason:    "not allowed",
+		connects:  make(chan *packets.Connect, 4),
+		publishes: make(chan *packets.Publish, 16),
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			b.mu.Lock()
+			b.conns = append(b.conns, conn)
+			b.mu.Unlock()
+			go b.serve(conn)
+		}
+	}()
+
+	return b
+}
+
+func (b *mqtt5Broker) broker() Broker {
+	addr := b.listener.Addr().(*net.TCPAddr)
+	return Broker{Host: addr.IP.String(), Port: addr.Port}
+}
+
+func (b *mqtt5Broker) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+
+		var reply *packets.ControlPacket
+		switch content := packet.Content.(type) {
+		case *packets.Connect:
+			b.connects <- content
+			reply = packets.NewControlPacket(packets.CONNACK)
+			reply.Content = &packets.Connack{ReasonCode: b.connack, Properties: b.properties(b.connack)}
+		case *packets.Publish:
+			b.publishes <- content
+			if content.QoS == 1 {
+				reply = packets.NewControlPacket(packets.PUBACK)
+				reply.Content = &packets.Puback{PacketID: content.PacketID, ReasonCode: b.puback, Properties: b.properties(b.puback)}
+			}
+		case *packets.Subscribe:
+			reply = packets.NewControlPacket(packets.SUBACK)
+			reply.Content = &packets.Suback{PacketID: content.PacketID, Reasons: []byte{b.suback}, Properties: b.properties(b.suback)}
+		case *packets.Pingreq:
+			reply = packets.NewControlPacket(packets.PINGRESP)
+		case *packets.Disconnect:
+			return
+		}
+
+		if reply != nil {
+			if _, err := reply.WriteTo(conn); err != nil {
+				return
+			}
+		}
+		if packet.Type == packets.CONNECT && b.connack >= 0x80 {
+			return
+		}
+	}
+}
+
+func (b *mqtt5Broker) properties(code byte) *packets.Properties {
+	if code < 0x80 {
+		return &packets.Properties{}
+	}
+
+	return &packets.Properties{ReasonString: b.reason}
+}
+
+func (b *mqtt5Broker) disconnectAll(code byte, reason string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, conn := range b.conns {
+		packet := packets.NewControlPacket(packets.DISCONNECT)
+		packet.Content = &packets.Disconnect{ReasonCode: code, Properties: &packets.Properties{ReasonString: reason}}
+		packet.WriteTo(conn)
+		conn.Close()
+	}
+}
+
+func connectMQTT5(t *testing.T, config TransportConfig) (*mqtt5Transport, error) {
+	t.Helper()
+
+	transport, err := newMQTT5Transport(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = transport.Connect()
+	t.Cleanup(transport.Disconnect)
+
+	return transport, err
+}
+
+func TestMQTT5ConnectPacket(t *testing.T) {
+	b := newMQTT5Broker(t, 0, 0, 0)
+	c := NewClient(Config{GroupID: "plant", NodeID: "edge-1"})
+
+	if _, err := connectMQTT5(t, TransportConfig{Broker: b.broker(), ClientID: "edge", SessionExpiry: 90 * time.Second, Will: c.will}); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	connect := <-b.connects
+	if !connect.WillFlag || connect.WillTopic != "spBv1.0/plant/NDEATH/edge-1" || !connect.WillRetain || connect.WillQOS != 0 {
+		t.Errorf("will = flag %t topic %q retain %t qos %d, want a retained QoS 0 NDEATH", connect.WillFlag, connect.WillTopic, connect.WillRetain, connect.WillQOS)
+	}
+
+	var death sproto.Payload
+	if err := proto.Unmarshal(connect.WillMessage, &death); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := findMetric(&death, "bdSeq"); !ok || death.Seq != nil {
+		t.Errorf("will payload = %v, want an NDEATH with bdSeq and no seq", &death)
+	}
+
+	properties := connect.Properties
+	if properties == nil || properties.SessionExpiryInterval == nil || *properties.SessionExpiryInterval != 90 {
+		t.Fatalf("CONNECT session expiry = %+v, want 90 seconds", properties)
+	}
+	if properties.RequestProblemInfo != nil && *properties.RequestProblemInfo != 1 {
+		t.Errorf("CONNECT request problem information = %d, want it left enabled", *properties.RequestProblemInfo)
+	}
+	if connect.CleanStart {
+		t.Error("CONNECT clean start = true with a session expiry, want false")
+	}
+}
+
+func TestMQTT5ConnectWithoutWill(t *testing.T) {
+	b := newMQTT5Broker(t, 0, 0, 0)
+
+	if _, err := connectMQTT5(t, TransportConfig{Broker: b.broker(), ClientID: "edge"}); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	if connect := <-b.connects; connect.WillFlag || !connect.CleanStart {
+		t.Errorf("CONNECT will %t clean start %t, want no will and a clean start", connect.WillFlag, connect.CleanStart)
+	}
+}
+
+func TestMQTT5PublishProperties(t *testing.T) {
+	tests := []struct {
+		name    string
+		options PublishOptions
+		expiry  uint32
+		user    []packets.User
+	}{
+		{name: "no properties"},
+		{name: "expiry rounded up", options: PublishOptions{MessageExpiry: 1500 * time.Millisecond}, expiry: 2},
+		{
+			name:    "sorted user properties",
+			options: PublishOptions{UserProperties: map[string]string{"site": "north", "line": "2"}},
+			user:    []packets.User{{Key: "line", Value: "2"}, {Key: "site", Value: "north"}},
+		},
+	}
+
+	b := newMQTT5Broker(t, 0, 0, 0)
+	transport, err := connectMQTT5(t, TransportConfig{Broker: b.broker(), ClientID: "edge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := transport.Publish("spBv1.0/plant/NDATA/edge-1", 0, false, []byte{1}, tt.options); err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+
+			var publish *packets.Publish
+			select {
+			case publish = <-b.publishes:
+			case <-time.After(loopbackTimeout):
+				t.Fatal("timed out waiting for PUBLISH")
+			}
+
+			var expiry uint32
+			if publish.Properties != nil && publish.Properties.MessageExpiry != nil {
+				expiry = *publish.Properties.MessageExpiry
+			}
+			if expiry != tt.expiry {
+				t.Errorf("message expiry = %d, want %d", expiry, tt.expiry)
+			}
+
+			var user []packets.User
+			if publish.Properties != nil {
+				user = publish.Properties.User
+			}
+			if len(user) != len(tt.user) {
+				t.Fatalf("user properties = %v, want %v", user, tt.user)
+			}
+			for i := range user {
+				if user[i] != tt.user[i] {
+					t.Errorf("user property %d = %v, want %v", i, user[i], tt.user[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMQTT5ReasonCodeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		broker func(t *testing.T) *mqtt5Broker
+		action func(transport *mqtt5Transport, connectErr error) error
+		packet string
+		code   byte
+	}{
+		{
+			name:   "connack",
+			broker: func(t *testing.T) *mqtt5Broker { return newMQTT5Broker(t, 0x87, 0, 0) },
+			action: func(_ *mqtt5Transport, connectErr error) error { return connectErr },
+			packet: "CONNACK",
+			code:   0x87,
+		},
+		{
+			name:   "puback",
+			broker: func(t *testing.T) *mqtt5Broker { return newMQTT5Broker(t, 0, 0x97, 0) },
+			action: func(transport *mqtt5Transport, _ error) error {
+				return transport.Publish("spBv1.0/plant/NDATA/edge-1", 1, false, []byte{1}, PublishOptions{})
+			},
+			packet: "PUBACK",
+			code:   0x97,
+		},
+		{
+			name:   "suback",
+			broker: func(t *testing.T) *mqtt5Broker { return newMQTT5Broker(t, 0, 0, 0x87) },
+			action: func(transport *mqtt5Transport, _ error) error {
+				return transport.Subscribe("spBv1.0/plant/NCMD/edge-1", 0, func(TransportMessage) {})
+			},
+			packet: "SUBACK",
+			code:   0x87,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.broker(t)
+			transport, connectErr := connectMQTT5(t, TransportConfig{Broker: b.broker(), ClientID: "edge"})
+
+			err := tt.action(transport, connectErr)
+
+			var reasonErr *ReasonCodeError
+			if !errors.As(err, &reasonErr) {
+				t.Fatalf("error = %v, want *ReasonCodeError", err)
+			}
+			if reasonErr.Packet != tt.packet || reasonErr.Code != tt.code || reasonErr.Reason != "not allowed" {
+				t.Errorf("ReasonCodeError = %+v, want %s 0x%02X not allowed", reasonErr, tt.packet, tt.code)
+			}
+		})
+	}
+}
+
+func TestMQTT5ServerDisconnectReason(t *testing.T) {
+	b := newMQTT5Broker(t, 0, 0, 0)
+
+	lost := make(chan error, 1)
+	_, err := connectMQTT5(t, TransportConfig{
+		Broker:           b.broker(),
+		ClientID:         "edge",
+		OnConnectionLost: func(err error) { lost <- err },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.disconnectAll(0x8B, "server shutting down")
+
+	select {
+	case err := <-lost:
+		var reasonErr *ReasonCodeError
+		if !errors.As(err, &reasonErr) || reasonErr.Packet != "DISCONNECT" || reasonErr.Code != 0x8B || reasonErr.Reason != "server shutting down" {
+			t.Errorf("connection lost error = %v, want DISCONNECT 0x8B from the server", err)
+		}
+	case <-time.After(loopbackTimeout):
+		t.Fatal("timed out waiting for the connection loss")
+	}
+}
+
+func TestReasonCodeErrorMessage(t *testing.T) {
+	tests := []struct {
+		err  ReasonCodeError
+		want string
+	}{
+		{ReasonCodeError{Packet: "PUBACK", Code: 0x97}, "PUBACK returned reason code 0x97"},
+		{ReasonCodeError{Packet: "CONNACK", Code: 0x87, Reason: "not authorized"}, "CONNACK returned reason code 0x87: not authorized"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestUserPropertiesRoundTrip(t *testing.T) {
+	if got := fromUserProperties(toUserProperties(nil)); got != nil {
+		t.Errorf("round trip of no properties = %v, want nil", got)
+	}
+
+	properties := map[string]string{"site": "north", "line": "2"}
+	got := fromUserProperties(toUserProperties(properties))
+	if len(got) != len(properties) || got["site"] != "north" || got["line"] != "2" {
+		t.Errorf("round trip = %v, want %v", got, properties)
+	}
+}
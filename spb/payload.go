@@ -8,130 +8,128 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
-func (c *Client) buildNBIRTHPayload() ([]byte, error) {
-	payload := &sproto.Payload{
-		Timestamp: proto.Uint64(uint64(time.Now().UnixMilli())),
-		Seq:       proto.Uint64(c.Seq),
-		Metrics: []*sproto.Payload_Metric{
-			ToMetric("bdSeq", c.BdSeq),
-			ToMetric("Node Control/Rebirth", false),
-			ToMetric("Node Control/Reboot", false),
-		},
+func (c *Client) buildNBIRTHPayload() (*sproto.Payload, error) {
+	if err := c.nodeMetrics.update(map[string]any{"bdSeq": c.currentBdSeq()}); err != nil {
+		return nil, fmt.Errorf("failed to update bdSeq metric: %w", err)
+	}
+
+	metrics, err := c.nodeMetrics.birthMetrics()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build NBIRTH metrics: %w", err)
 	}
 
-	payloadBytes, err := proto.Marshal(payload)
+	templates, err := c.templates.birthMetrics()
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal NBIRTH payload: %w", err)
+		return nil, fmt.Errorf("failed to build NBIRTH template definitions: %w", err)
 	}
+	metrics = append(metrics, templates...)
 
-	return payloadBytes, nil
+	payload := &sproto.Payload{
+		Timestamp: proto.Uint64(uint64(time.Now().UnixMilli())),
+		Metrics:   metrics,
+	}
+
+	return payload, nil
 }
 
-func (c *Client) buildNDEATHPayload() ([]byte, error) {
+func (c *Client) buildNDEATHPayload() (*sproto.Payload, error) {
 	payload := &sproto.Payload{
 		Timestamp: proto.Uint64(uint64(time.Now().UnixMilli())),
-		Seq:       proto.Uint64(c.Seq),
-		Metrics: []*sproto.Payload_Metric{
-			ToMetric("bdSeq", c.BdSeq),
-			ToMetric("Node Control/Rebirth", false),
-			ToMetric("Node Control/Reboot", false),
-		},
+		Metrics:   make([]*sproto.Payload_Metric, 0, 3),
 	}
 
-	payloadBytes, err := proto.Marshal(payload)
+	bdSeq, err := NewMetric("bdSeq", sproto.DataType_Int64, c.currentBdSeq())
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal NDEATH payload: %w", err)
+		return nil, fmt.Errorf("failed to build NDEATH metric: %w", err)
 	}
+	payload.Metrics = append(payload.Metrics, bdSeq)
 
-	return payloadBytes, nil
+	for _, name := range []string{"Node Control/Rebirth", "Node Control/Reboot"} {
+		metric, err := ToMetric(name, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build NDEATH metric: %w", err)
+		}
+		payload.Metrics = append(payload.Metrics, metric)
+	}
+
+	return payload, nil
 }
 
-func (c *Client) buildDBIRTHPayload(d Device) ([]byte, error) {
+func (c *Client) buildDBIRTHPayload(d Device) (*sproto.Payload, error) {
+	registry := c.DeviceMetrics(d.GetId())
+	if defined, ok := d.(DefinedDevice); ok {
+		if err := registry.declareAll(defined.MetricDefinitions()); err != nil {
+			return nil, err
+		}
+	}
+
 	values := d.GetMetricValues()
-	metrics := make([]*sproto.Payload_Metric, 0, len(values))
-	for name, value := range values {
-		metric := ToMetric(name, value)
-		metrics = append(metrics, metric)
+	if err := registry.declareInferred(values); err != nil {
+		return nil, err
 	}
 
-	payload := &sproto.Payload{
-		Timestamp: proto.Uint64(uint64(time.Now().UnixMilli())),
-		Seq:       proto.Uint64(c.Seq),
-		Metrics: metrics,
+	if err := registry.update(values); err != nil {
+		return nil, err
 	}
-	
-	payloadBytes, err := proto.Marshal(payload)
+
+	metrics, err := registry.birthMetrics()
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal DBIRTH payload: %w", err)
+		return nil, err
 	}
 
-	return payloadBytes, nil
-}
-
-func (c *Client) buildDDEATHPayload() ([]byte, error) {
 	payload := &sproto.Payload{
 		Timestamp: proto.Uint64(uint64(time.Now().UnixMilli())),
-		Seq:       proto.Uint64(c.Seq),
+		Metrics:   metrics,
 	}
 
-	payloadBytes, err := proto.Marshal(payload)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal DDEATH payload: %w", err)
+	return payload, nil
+}
+
+func (c *Client) buildDDEATHPayload() (*sproto.Payload, error) {
+	payload := &sproto.Payload{
+		Timestamp: proto.Uint64(uint64(time.Now().UnixMilli())),
 	}
 
-	return payloadBytes, nil
+	return payload, nil
 }
 
-func (c *Client) buildNDATAPayload(metricValues map[string]any) ([]byte, error) {
+func (c *Client) buildNDATAPayload(metricValues []metricValue) (*sproto.Payload, error) {
 	if len(metricValues) == 0 {
 		return nil, fmt.Errorf("no metrics provided for NDATA payload")
 	}
 
-	metrics := make([]*sproto.Payload_Metric, 0, len(metricValues))
-	for name, value := range metricValues {
-		metric := ToMetric(name, value)
-		if metric != nil {
-			metrics = append(metrics, metric)
-		}
+	metrics, err := c.nodeMetrics.dataMetrics(metricValues)
+	if err != nil {
+		return nil, err
 	}
 
 	payload := &sproto.Payload{
 		Timestamp: proto.Uint64(uint64(time.Now().UnixMilli())),
-		Seq:       proto.Uint64(c.Seq),
 		Metrics:   metrics,
 	}
 
-	payloadBytes, err := proto.Marshal(payload)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal NDATA payload: %w", err)
-	}
-
-	return payloadBytes, nil
+	return payload, nil
 }
 
-func (c *Client) buildDDATAPayload(metricValues map[string]any) ([]byte, error) {
+func (c *Client) buildDDATAPayload(deviceID string, metricValues []metricValue) (*sproto.Payload, error) {
 	if len(metricValues) == 0 {
 		return nil, fmt.Errorf("no metrics provided for DDATA payload")
 	}
 
-	metrics := make([]*sproto.Payload_Metric, 0, len(metricValues))
-	for name, value := range metricValues {
-		metric := ToMetric(name, value)
-		if metric != nil {
-			metrics = append(metrics, metric)
-		}
+	registry, ok := c.deviceRegistry(deviceID)
+	if !ok {
+		return nil, fmt.Errorf("no metrics declared for device %s", deviceID)
 	}
 
-	payload := &sproto.Payload{
-		Timestamp: proto.Uint64(uint64(time.Now().UnixMilli())),
-		Seq:       proto.Uint64(c.Seq),
-		Metrics: metrics,
+	metrics, err := registry.dataMetrics(metricValues)
+	if err != nil {
+		return nil, err
 	}
 
-	payloadBytes, err := proto.Marshal(payload)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal DDATA payload: %w", err)
+	payload := &sproto.Payload{
+		Timestamp: proto.Uint64(uint64(time.Now().UnixMilli())),
+		Metrics:   metrics,
 	}
 
-	return payloadBytes, nil
-}
\ No newline at end of file
+	return payload, nil
+}
@@ -0,0 +1,254 @@
+package spb
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	PropertyEngUnit     = "engUnit"
+	PropertyEngLow      = "engLow"
+	PropertyEngHigh     = "engHigh"
+	PropertyQuality     = "Quality"
+	PropertyReadOnly    = "readOnly"
+	PropertyDescription = "description"
+	PropertyWriteError  = "writeError"
+)
+
+const (
+	QualityBad   int32 = 0
+	QualityGood  int32 = 192
+	QualityStale int32 = 500
+)
+
+type PropertyValue struct {
+	DataType sproto.DataType
+	Value    any
+}
+
+type PropertySet map[string]PropertyValue
+
+type MetricUpdate struct {
+	Value      any
+	Properties PropertySet
+}
+
+func NewProperty(value any) (PropertyValue, error) {
+	switch value.(type) {
+	case PropertySet:
+		return PropertyValue{DataType: sproto.DataType_PropertySet, Value: value}, nil
+	case []PropertySet:
+		return PropertyValue{DataType: sproto.DataType_PropertySetList, Value: value}, nil
+	}
+
+	dataType, ok := inferDataType(value)
+	if !ok || !isDataSetDataType(dataType) {
+		return PropertyValue{}, &UnsupportedValueError{Value: value}
+	}
+
+	return PropertyValue{DataType: dataType, Value: value}, nil
+}
+
+func NullProperty(dataType sproto.DataType) PropertyValue {
+	return PropertyValue{DataType: dataType}
+}
+
+func (p PropertySet) Set(key string, value any) error {
+	property, err := NewProperty(value)
+	if err != nil {
+		return fmt.Errorf("property %s: %w", key, err)
+	}
+
+	p[key] = property
+
+	return nil
+}
+
+func (p PropertySet) keys() []string {
+	keys := make([]string, 0, len(p))
+	for key := range p {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (p PropertySet) toProto() (*sproto.Payload_PropertySet, error) {
+	set := &sproto.Payload_PropertySet{}
+	for _, key := range p.keys() {
+		value, err := p[key].toProto()
+		if err != nil {
+			return nil, fmt.Errorf("property %s: %w", key, err)
+		}
+
+		set.Keys = append(set.Keys, key)
+		set.Values = append(set.Values, value)
+	}
+
+	return set, nil
+}
+
+func (v PropertyValue) toProto() (*sproto.Payload_PropertyValue, error) {
+	property := &sproto.Payload_PropertyValue{
+		Type: proto.Uint32(uint32(v.DataType)),
+	}
+
+	if v.Value == nil {
+		property.IsNull = proto.Bool(true)
+		return property, nil
+	}
+
+	switch v.DataType {
+	case sproto.DataType_PropertySet:
+		set, ok := v.Value.(PropertySet)
+		if !ok {
+			return nil, &MetricTypeError{DataType: v.DataType, Value: v.Value}
+		}
+		encoded, err := set.toProto()
+		if err != nil {
+			return nil, err
+		}
+		property.Value = &sproto.Payload_PropertyValue_PropertysetValue{PropertysetValue: encoded}
+		return property, nil
+
+	case sproto.DataType_PropertySetList:
+		sets, ok := v.Value.([]PropertySet)
+		if !ok {
+			return nil, &MetricTypeError{DataType: v.DataType, Value: v.Value}
+		}
+		list := &sproto.Payload_PropertySetList{}
+		for _, set := range sets {
+			encoded, err := set.toProto()
+			if err != nil {
+				return nil, err
+			}
+			list.Propertyset = append(list.Propertyset, encoded)
+		}
+		property.Value = &sproto.Payload_PropertyValue_PropertysetsValue{PropertysetsValue: list}
+		return property, nil
+	}
+
+	element, ok := encodeDataSetValue(v.DataType, v.Value)
+	if !ok {
+		return nil, &MetricTypeError{DataType: v.DataType, Value: v.Value}
+	}
+
+	switch e := element.GetValue().(type) {
+	case *sproto.Payload_DataSet_DataSetValue_IntValue:
+		property.Value = &sproto.Payload_PropertyValue_IntValue{IntValue: e.IntValue}
+	case *sproto.Payload_DataSet_DataSetValue_LongValue:
+		property.Value = &sproto.Payload_PropertyValue_LongValue{LongValue: e.LongValue}
+	case *sproto.Payload_DataSet_DataSetValue_FloatValue:
+		property.Value = &sproto.Payload_PropertyValue_FloatValue{FloatValue: e.FloatValue}
+	case *sproto.Payload_DataSet_DataSetValue_DoubleValue:
+		property.Value = &sproto.Payload_PropertyValue_DoubleValue{DoubleValue: e.DoubleValue}
+	case *sproto.Payload_DataSet_DataSetValue_BooleanValue:
+		property.Value = &sproto.Payload_PropertyValue_BooleanValue{BooleanValue: e.BooleanValue}
+	case *sproto.Payload_DataSet_DataSetValue_StringValue:
+		property.Value = &sproto.Payload_PropertyValue_StringValue{StringValue: e.StringValue}
+	}
+
+	return property, nil
+}
+
+func DecodePropertySet(set *sproto.Payload_PropertySet) (PropertySet, error) {
+	if len(set.GetKeys()) != len(set.GetValues()) {
+		return nil, fmt.Errorf("property set has %d keys but %d values", len(set.GetKeys()), len(set.GetValues()))
+	}
+
+	properties := make(PropertySet, len(set.GetKeys()))
+	for i, key := range set.GetKeys() {
+		value, err := decodePropertyValue(set.GetValues()[i])
+		if err != nil {
+			return nil, fmt.Errorf("property %s: %w", key, err)
+		}
+		properties[key] = value
+	}
+
+	return properties, nil
+}
+
+func MetricProperties(metric *sproto.Payload_Metric) (PropertySet, error) {
+	if metric.GetProperties() == nil {
+		return nil, nil
+	}
+
+	return DecodePropertySet(metric.GetProperties())
+}
+
+func decodePropertyValue(property *sproto.Payload_PropertyValue) (PropertyValue, error) {
+	dataType := sproto.DataType(property.GetType())
+	if property.GetIsNull() {
+		return NullProperty(dataType), nil
+	}
+
+	element := &sproto.Payload_DataSet_DataSetValue{}
+	switch v := property.GetValue().(type) {
+	case nil:
+		return NullProperty(dataType), nil
+
+	case *sproto.Payload_PropertyValue_PropertysetValue:
+		if dataType != sproto.DataType_PropertySet {
+			return PropertyValue{}, fmt.Errorf("property set value does not match datatype %s", dataType)
+		}
+		set, err := DecodePropertySet(v.PropertysetValue)
+		if err != nil {
+			return PropertyValue{}, err
+		}
+		return PropertyValue{DataType: dataType, Value: set}, nil
+
+	case *sproto.Payload_PropertyValue_PropertysetsValue:
+		if dataType != sproto.DataType_PropertySetList {
+			return PropertyValue{}, fmt.Errorf("property set list value does not match datatype %s", dataType)
+		}
+		sets := make([]PropertySet, 0, len(v.PropertysetsValue.GetPropertyset()))
+		for _, encoded := range v.PropertysetsValue.GetPropertyset() {
+			set, err := DecodePropertySet(encoded)
+			if err != nil {
+				return PropertyValue{}, err
+			}
+			sets = append(sets, set)
+		}
+		return PropertyValue{DataType: dataType, Value: sets}, nil
+
+	case *sproto.Payload_PropertyValue_IntValue:
+		element.Value = &sproto.Payload_DataSet_DataSetValue_IntValue{IntValue: v.IntValue}
+	case *sproto.Payload_PropertyValue_LongValue:
+		element.Value = &sproto.Payload_DataSet_DataSetValue_LongValue{LongValue: v.LongValue}
+	case *sproto.Payload_PropertyValue_FloatValue:
+		element.Value = &sproto.Payload_DataSet_DataSetValue_FloatValue{FloatValue: v.FloatValue}
+	case *sproto.Payload_PropertyValue_DoubleValue:
+		element.Value = &sproto.Payload_DataSet_DataSetValue_DoubleValue{DoubleValue: v.DoubleValue}
+	case *sproto.Payload_PropertyValue_BooleanValue:
+		element.Value = &sproto.Payload_DataSet_DataSetValue_BooleanValue{BooleanValue: v.BooleanValue}
+	case *sproto.Payload_PropertyValue_StringValue:
+		element.Value = &sproto.Payload_DataSet_DataSetValue_StringValue{StringValue: v.StringValue}
+	default:
+		return PropertyValue{}, fmt.Errorf("unsupported property value %T", v)
+	}
+
+	value, err := decodeDataSetValue(dataType, element)
+	if err != nil {
+		return PropertyValue{}, err
+	}
+
+	return PropertyValue{DataType: dataType, Value: value}, nil
+}
+
+func splitProperties(value any) (any, PropertySet) {
+	switch v := value.(type) {
+	case MetricUpdate:
+		return v.Value, v.Properties
+	case *MetricUpdate:
+		if v == nil {
+			return nil, nil
+		}
+		return v.Value, v.Properties
+	default:
+		return value, nil
+	}
+}
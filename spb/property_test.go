@@ -0,0 +1,201 @@
+package spb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestPropertySetRoundTrip(t *testing.T) {
+	limits := PropertySet{}
+	if err := limits.Set("alarm", 2800.0); err != nil {
+		t.Fatal(err)
+	}
+
+	properties := PropertySet{
+		PropertyEngUnit:  {DataType: sproto.DataType_String, Value: "rpm"},
+		PropertyEngLow:   {DataType: sproto.DataType_Double, Value: 0.0},
+		PropertyQuality:  {DataType: sproto.DataType_Int32, Value: QualityGood},
+		PropertyReadOnly: {DataType: sproto.DataType_Boolean, Value: true},
+		"offset":         {DataType: sproto.DataType_Int16, Value: int16(-40)},
+		"comment":        NullProperty(sproto.DataType_String),
+		"limits":         {DataType: sproto.DataType_PropertySet, Value: limits},
+		"history": {DataType: sproto.DataType_PropertySetList, Value: []PropertySet{
+			{"at": {DataType: sproto.DataType_UInt32, Value: uint32(1)}},
+			{},
+		}},
+	}
+
+	encoded, err := properties.toProto()
+	if err != nil {
+		t.Fatalf("toProto() error = %v", err)
+	}
+
+	if want := properties.keys(); !reflect.DeepEqual(encoded.Keys, want) {
+		t.Errorf("encoded keys = %v, want sorted %v", encoded.Keys, want)
+	}
+
+	metric := &sproto.Payload_Metric{Name: proto.String("speed"), Properties: encoded}
+	decoded := marshalRoundTrip(t, &sproto.Payload{Metrics: []*sproto.Payload_Metric{metric}})
+
+	got, err := MetricProperties(decoded.Metrics[0])
+	if err != nil {
+		t.Fatalf("MetricProperties() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(got, properties) {
+		t.Errorf("MetricProperties() = %#v, want %#v", got, properties)
+	}
+}
+
+func TestNewProperty(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  sproto.DataType
+		err   bool
+	}{
+		{name: "int32", value: QualityStale, want: sproto.DataType_Int32},
+		{name: "string", value: "degC", want: sproto.DataType_String},
+		{name: "double", value: 1.5, want: sproto.DataType_Double},
+		{name: "property set", value: PropertySet{}, want: sproto.DataType_PropertySet},
+		{name: "property set list", value: []PropertySet{{}}, want: sproto.DataType_PropertySetList},
+		{name: "array", value: []int8{1}, err: true},
+		{name: "bytes", value: []byte{1}, err: true},
+		{name: "complex", value: complex(1, 2), err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewProperty(tt.value)
+			if tt.err {
+				var unsupported *UnsupportedValueError
+				if !errors.As(err, &unsupported) {
+					t.Fatalf("NewProperty() error = %v, want *UnsupportedValueError", err)
+				}
+				return
+			}
+
+			if err != nil || got.DataType != tt.want {
+				t.Errorf("NewProperty() = %s, %v, want %s", got.DataType, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPropertyValueTypeMismatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		property PropertyValue
+	}{
+		{"string as int32", PropertyValue{DataType: sproto.DataType_Int32, Value: "good"}},
+		{"int8 out of range", PropertyValue{DataType: sproto.DataType_Int8, Value: 300}},
+		{"value as property set", PropertyValue{DataType: sproto.DataType_PropertySet, Value: 1}},
+		{"set as property set list", PropertyValue{DataType: sproto.DataType_PropertySetList, Value: PropertySet{}}},
+		{"nested mismatch", PropertyValue{DataType: sproto.DataType_PropertySet, Value: PropertySet{
+			"alarm": {DataType: sproto.DataType_Boolean, Value: "yes"},
+		}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := PropertySet{"p": tt.property}.toProto()
+
+			var typeErr *MetricTypeError
+			if !errors.As(err, &typeErr) {
+				t.Errorf("toProto() error = %v, want *MetricTypeError", err)
+			}
+		})
+	}
+}
+
+func TestDecodePropertySetErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		set  *sproto.Payload_PropertySet
+	}{
+		{
+			name: "keys without values",
+			set:  &sproto.Payload_PropertySet{Keys: []string{"engUnit"}},
+		},
+		{
+			name: "property set under scalar datatype",
+			set: &sproto.Payload_PropertySet{
+				Keys: []string{"limits"},
+				Values: []*sproto.Payload_PropertyValue{{
+					Type:  proto.Uint32(uint32(sproto.DataType_Int32)),
+					Value: &sproto.Payload_PropertyValue_PropertysetValue{PropertysetValue: &sproto.Payload_PropertySet{}},
+				}},
+			},
+		},
+		{
+			name: "value does not match datatype",
+			set: &sproto.Payload_PropertySet{
+				Keys: []string{"Quality"},
+				Values: []*sproto.Payload_PropertyValue{{
+					Type:  proto.Uint32(uint32(sproto.DataType_Int32)),
+					Value: &sproto.Payload_PropertyValue_StringValue{StringValue: "good"},
+				}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodePropertySet(tt.set); err == nil {
+				t.Error("DecodePropertySet() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestQualityProperties(t *testing.T) {
+	c := NewClient(Config{GroupID: "g", NodeID: "n"})
+	_, err := c.NodeMetrics().Declare(MetricDefinition{
+		Name:       "speed",
+		DataType:   sproto.DataType_Double,
+		Properties: PropertySet{PropertyEngUnit: {DataType: sproto.DataType_String, Value: "rpm"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	birth, err := c.buildNBIRTHPayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metric, _ := findMetric(birth, "speed")
+	properties, err := MetricProperties(metric)
+	if err != nil || properties[PropertyEngUnit].Value != "rpm" {
+		t.Errorf("NBIRTH properties = %v, %v, want engUnit rpm", properties, err)
+	}
+
+	data, err := c.buildNDATAPayload(orderedValues(map[string]any{
+		"speed": MetricUpdate{
+			Value:      1420.0,
+			Properties: PropertySet{PropertyQuality: {DataType: sproto.DataType_Int32, Value: QualityStale}},
+		},
+	}))
+	if err != nil {
+		t.Fatalf("buildNDATAPayload() error = %v", err)
+	}
+
+	properties, err = MetricProperties(data.Metrics[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quality := properties[PropertyQuality]; quality.DataType != sproto.DataType_Int32 || quality.Value != QualityStale {
+		t.Errorf("NDATA Quality = %+v, want Int32 %d", quality, QualityStale)
+	}
+	if data.Metrics[0].GetDoubleValue() != 1420 {
+		t.Errorf("NDATA value = %v, want 1420", data.Metrics[0].GetDoubleValue())
+	}
+
+	if value, _ := c.NodeMetrics().Value("speed"); value != 1420.0 {
+		t.Errorf("registry value = %#v, want the unwrapped 1420", value)
+	}
+}
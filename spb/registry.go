@@ -0,0 +1,307 @@
+package spb
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+type MetricDefinition struct {
+	Name       string
+	DataType   sproto.DataType
+	Alias      *uint64
+	Properties PropertySet
+	Writable   bool
+
+	Deadband        float64
+	DeadbandPercent float64
+
+	ScanClass string
+}
+
+type metricValue struct {
+	name  string
+	value any
+}
+
+type UnknownMetricError struct {
+	DeviceID string
+	Name     string
+}
+
+func (e *UnknownMetricError) Error() string {
+	if e.DeviceID == "" {
+		return fmt.Sprintf("metric %s was not declared in the node birth", e.Name)
+	}
+
+	return fmt.Sprintf("metric %s was not declared in the birth of device %s", e.Name, e.DeviceID)
+}
+
+type MetricRegistry struct {
+	mu          sync.RWMutex
+	deviceID    string
+	aliases     *aliasTable
+	templates   *templateRegistry
+	definitions map[string]*MetricDefinition
+	inferred    map[string]bool
+	order       []string
+	values      map[string]any
+	reported    map[string]reportedValue
+	rebirth     bool
+}
+
+func newMetricRegistry(deviceID string, aliases *aliasTable, templates *templateRegistry) *MetricRegistry {
+	return &MetricRegistry{
+		deviceID:    deviceID,
+		aliases:     aliases,
+		templates:   templates,
+		definitions: make(map[string]*MetricDefinition),
+		inferred:    make(map[string]bool),
+		values:      make(map[string]any),
+		reported:    make(map[string]reportedValue),
+	}
+}
+
+func (r *MetricRegistry) Declare(definition MetricDefinition) (MetricDefinition, error) {
+	if definition.Name == "" {
+		return MetricDefinition{}, fmt.Errorf("metric name must not be empty")
+	}
+
+	if definition.DataType == sproto.DataType_Unknown {
+		return MetricDefinition{}, fmt.Errorf("metric %s must declare a datatype", definition.Name)
+	}
+
+	if err := validateDeadband(definition); err != nil {
+		return MetricDefinition{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.definitions[definition.Name]; ok {
+		return MetricDefinition{}, fmt.Errorf("metric %s is already declared", definition.Name)
+	}
+
+	if definition.Alias != nil {
+		if err := r.aliases.reserve(r.deviceID, definition.Name, *definition.Alias); err != nil {
+			return MetricDefinition{}, fmt.Errorf("failed to declare metric %s: %w", definition.Name, err)
+		}
+		definition.Alias = proto.Uint64(*definition.Alias)
+	} else {
+		definition.Alias = proto.Uint64(r.aliases.assign(r.deviceID, definition.Name))
+	}
+	r.definitions[definition.Name] = &definition
+	r.order = append(r.order, definition.Name)
+	r.rebirth = true
+
+	return definition, nil
+}
+
+func (r *MetricRegistry) Definition(name string) (MetricDefinition, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	definition, ok := r.definitions[name]
+	if !ok {
+		return MetricDefinition{}, false
+	}
+
+	return *definition, true
+}
+
+func (r *MetricRegistry) Definitions() []MetricDefinition {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	definitions := make([]MetricDefinition, 0, len(r.order))
+	for _, name := range r.order {
+		definitions = append(definitions, *r.definitions[name])
+	}
+
+	return definitions
+}
+
+func (r *MetricRegistry) Value(name string) (any, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	value, ok := r.values[name]
+	return value, ok
+}
+
+func (r *MetricRegistry) declareAll(definitions []MetricDefinition) error {
+	for _, definition := range definitions {
+		if _, ok := r.Definition(definition.Name); ok {
+			continue
+		}
+
+		if _, err := r.Declare(definition); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *MetricRegistry) declareInferred(metricValues map[string]any) error {
+	for _, name := range sortedNames(metricValues) {
+		if _, ok := r.Definition(name); ok {
+			continue
+		}
+
+		value, properties := splitProperties(metricValues[name])
+		dataType, ok := inferDataType(value)
+		if !ok {
+			return &UnsupportedValueError{Name: name, Value: value}
+		}
+
+		if _, err := r.Declare(MetricDefinition{Name: name, DataType: dataType, Properties: properties}); err != nil {
+			return err
+		}
+
+		r.mu.Lock()
+		r.inferred[name] = true
+		r.mu.Unlock()
+	}
+
+	return nil
+}
+
+func (r *MetricRegistry) isInferred(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.inferred[name]
+}
+
+func (r *MetricRegistry) update(metricValues map[string]any) error {
+	return r.updateValues(orderedValues(metricValues))
+}
+
+func (r *MetricRegistry) updateValues(values []metricValue) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, mv := range values {
+		name := mv.name
+		value, properties := splitProperties(mv.value)
+		definition, ok := r.definitions[name]
+		if !ok {
+			return &UnknownMetricError{DeviceID: r.deviceID, Name: name}
+		}
+
+		if _, ok := encodeValue(definition.DataType, value); !ok {
+			return &MetricTypeError{Name: name, DataType: definition.DataType, Value: value}
+		}
+
+		if instance, ok := value.(*Template); ok {
+			if err := r.templates.validate(instance); err != nil {
+				return fmt.Errorf("metric %s: %w", name, err)
+			}
+		}
+
+		if _, err := properties.toProto(); err != nil {
+			return fmt.Errorf("metric %s: %w", name, err)
+		}
+	}
+
+	for _, mv := range values {
+		r.values[mv.name], _ = splitProperties(mv.value)
+	}
+
+	return nil
+}
+
+func (r *MetricRegistry) needsRebirth() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.rebirth
+}
+
+func (r *MetricRegistry) birthMetrics() ([]*sproto.Payload_Metric, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	metrics := make([]*sproto.Payload_Metric, 0, len(r.order))
+	for _, name := range r.order {
+		definition := r.definitions[name]
+
+		var metric *sproto.Payload_Metric
+		if value, ok := r.values[name]; ok && value != nil {
+			var err error
+			metric, err = NewMetric(name, definition.DataType, value)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			metric = newNullMetric(name, definition.DataType)
+		}
+
+		metric.Alias = proto.Uint64(*definition.Alias)
+		if len(definition.Properties) > 0 {
+			properties, err := definition.Properties.toProto()
+			if err != nil {
+				return nil, fmt.Errorf("metric %s: %w", name, err)
+			}
+			metric.Properties = properties
+		}
+		metrics = append(metrics, metric)
+	}
+	r.rebirth = false
+
+	return metrics, nil
+}
+
+func (r *MetricRegistry) dataMetrics(values []metricValue) ([]*sproto.Payload_Metric, error) {
+	if err := r.updateValues(values); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	metrics := make([]*sproto.Payload_Metric, 0, len(values))
+	for _, mv := range values {
+		name := mv.name
+		definition := r.definitions[name]
+		value, properties := splitProperties(mv.value)
+		metric, err := NewMetric(name, definition.DataType, value)
+		if err != nil {
+			return nil, err
+		}
+
+		metric.Name = nil
+		metric.Alias = proto.Uint64(*definition.Alias)
+		if len(properties) > 0 {
+			metric.Properties, err = properties.toProto()
+			if err != nil {
+				return nil, fmt.Errorf("metric %s: %w", name, err)
+			}
+		}
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, nil
+}
+
+func orderedValues(metricValues map[string]any) []metricValue {
+	values := make([]metricValue, 0, len(metricValues))
+	for _, name := range sortedNames(metricValues) {
+		values = append(values, metricValue{name: name, value: metricValues[name]})
+	}
+
+	return values
+}
+
+func sortedNames(metricValues map[string]any) []string {
+	names := make([]string, 0, len(metricValues))
+	for name := range metricValues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,83 @@
+package spb
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDeclareAliases(t *testing.T) {
+	tests := []struct {
+		name    string
+		alias   *uint64
+		want    uint64
+		wantErr bool
+	}{
+		{name: "Explicit", alias: proto.Uint64(0), want: 0},
+		{name: "Assigned", want: 1},
+		{name: "High", alias: proto.Uint64(100), want: 100},
+		{name: "Next", want: 2},
+		{name: "Conflict", alias: proto.Uint64(100), wantErr: true},
+	}
+
+	r := newMetricRegistry("", newAliasTable(), newTemplateRegistry())
+	for _, tt := range tests {
+		definition, err := r.Declare(MetricDefinition{Name: tt.name, DataType: sproto.DataType_Int32, Alias: tt.alias})
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("Declare(%s) error = %v, wantErr %t", tt.name, err, tt.wantErr)
+		}
+		if tt.wantErr {
+			continue
+		}
+
+		if definition.Alias == nil || *definition.Alias != tt.want {
+			t.Errorf("Declare(%s) alias = %v, want %d", tt.name, definition.Alias, tt.want)
+		}
+	}
+}
+
+func TestPublishNDATARejectsTypeMismatch(t *testing.T) {
+	loop := NewLoopback()
+	_, events := newLoopbackHost(t, loop)
+	c := newTestClient(t, loop, Config{})
+	if _, err := c.NodeMetrics().Declare(MetricDefinition{Name: "Count", DataType: sproto.DataType_Int64}); err != nil {
+		t.Fatal(err)
+	}
+	connectTestClient(t, c)
+	waitForEvent(t, events, MessageTypeNBIRTH)
+
+	err := c.PublishNDATA(map[string]any{"Count": "many"})
+
+	var typeErr *MetricTypeError
+	if !errors.As(err, &typeErr) {
+		t.Fatalf("PublishNDATA() error = %v, want *MetricTypeError", err)
+	}
+}
+
+func TestPublishNDATARejectsUnknownMetrics(t *testing.T) {
+	loop := NewLoopback()
+	_, events := newLoopbackHost(t, loop)
+	c := newTestClient(t, loop, Config{})
+	connectTestClient(t, c)
+	waitForEvent(t, events, MessageTypeNBIRTH)
+
+	err := c.PublishNDATA(map[string]any{"Temperature": 21.5})
+
+	var unknownErr *UnknownMetricError
+	if !errors.As(err, &unknownErr) || unknownErr.Name != "Temperature" || unknownErr.DeviceID != "" {
+		t.Fatalf("PublishNDATA() error = %v, want *UnknownMetricError for Temperature", err)
+	}
+	if _, ok := c.NodeMetrics().Definition("Temperature"); ok {
+		t.Error("PublishNDATA() declared Temperature")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case event := <-events:
+		t.Errorf("received unexpected %s", event.messageType)
+	default:
+	}
+}
@@ -0,0 +1,183 @@
+package spb
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+)
+
+const scanRateMetric = "Node Control/Scan Rate"
+
+func (c *Client) declareScanRate() error {
+	var scanRates map[string]time.Duration
+	if c.Config.ScanRate > 0 {
+		scanRates = map[string]time.Duration{"": c.Config.ScanRate}
+		for class, rate := range c.Config.ScanClasses {
+			scanRates[class] = rate
+		}
+	}
+
+	c.mu.Lock()
+	c.scanRates = scanRates
+	c.mu.Unlock()
+
+	if scanRates == nil {
+		return nil
+	}
+
+	if _, ok := c.nodeMetrics.Definition(scanRateMetric); !ok {
+		if _, err := c.nodeMetrics.Declare(MetricDefinition{Name: scanRateMetric, DataType: sproto.DataType_Int64, Writable: true}); err != nil {
+			return err
+		}
+	}
+	if err := c.nodeMetrics.update(map[string]any{scanRateMetric: c.Config.ScanRate.Milliseconds()}); err != nil {
+		return err
+	}
+	c.HandleNodeCommand(scanRateMetric, CommandHandler{Handle: c.onScanRateCommand, Echo: true})
+
+	return nil
+}
+
+func (c *Client) ScanRate(class string) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rate, ok := c.scanRates[class]
+	return rate, ok
+}
+
+func (c *Client) SetScanRate(class string, rate time.Duration) error {
+	if rate <= 0 {
+		return fmt.Errorf("scan rate for class %q must be positive", class)
+	}
+
+	c.mu.Lock()
+	if _, ok := c.scanRates[class]; !ok {
+		c.mu.Unlock()
+		return fmt.Errorf("scan class %q is not configured", class)
+	}
+	c.scanRates[class] = rate
+	reset := c.scanResets[class]
+	c.mu.Unlock()
+
+	if class == "" {
+		if err := c.nodeMetrics.update(map[string]any{scanRateMetric: rate.Milliseconds()}); err != nil {
+			return fmt.Errorf("failed to update scan rate metric: %w", err)
+		}
+	}
+
+	if reset != nil {
+		select {
+		case reset <- struct{}{}:
+		default:
+		}
+	}
+
+	log.Printf("Scan rate for class %q set to %s", class, rate)
+
+	return nil
+}
+
+func (c *Client) startScanning() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.scanRates) == 0 || c.scanStop != nil {
+		return
+	}
+
+	c.scanStop = make(chan struct{})
+	c.scanResets = make(map[string]chan struct{}, len(c.scanRates))
+	for class := range c.scanRates {
+		reset := make(chan struct{}, 1)
+		c.scanResets[class] = reset
+		go c.scanLoop(class, c.scanStop, reset)
+	}
+}
+
+func (c *Client) stopScanning() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.scanStop != nil {
+		close(c.scanStop)
+		c.scanStop = nil
+		c.scanResets = nil
+	}
+}
+
+func (c *Client) scanLoop(class string, stop <-chan struct{}, reset <-chan struct{}) {
+	rate, _ := c.ScanRate(class)
+	timer := time.NewTimer(rate)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-reset:
+		case <-timer.C:
+			c.scan(class)
+		}
+
+		rate, _ = c.ScanRate(class)
+		timer.Reset(rate)
+	}
+}
+
+func (c *Client) scan(class string) {
+	c.mu.Lock()
+	born := c.born
+	c.mu.Unlock()
+	if !born {
+		return
+	}
+
+	for _, device := range c.Devices() {
+		if !c.IsDeviceBorn(device.GetId()) {
+			continue
+		}
+
+		registry := c.DeviceMetrics(device.GetId())
+		due := make(map[string]any)
+		for name, value := range device.GetMetricValues() {
+			if c.scanClass(registry, name) == class {
+				due[name] = value
+			}
+		}
+
+		if len(due) == 0 {
+			continue
+		}
+
+		if err := c.ReportDDATA(device, due); err != nil {
+			log.Printf("Failed to report scanned metrics for device %s: %v", device.GetId(), err)
+		}
+	}
+}
+
+func (c *Client) scanClass(registry *MetricRegistry, name string) string {
+	definition, ok := registry.Definition(name)
+	if !ok {
+		return ""
+	}
+
+	if _, ok := c.ScanRate(definition.ScanClass); !ok {
+		return ""
+	}
+
+	return definition.ScanClass
+}
+
+func (c *Client) onScanRateCommand(cmd Command) error {
+	rate, ok := toInt64(cmd.Value)
+	if !ok || rate <= 0 {
+		return c.rejectWrite(cmd, "scan rate must be a positive number of milliseconds")
+	}
+
+	log.Printf("Received Scan Rate command")
+
+	return c.SetScanRate("", time.Duration(rate)*time.Millisecond)
+}
@@ -0,0 +1,184 @@
+package spb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+)
+
+func TestSetScanRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		class   string
+		rate    time.Duration
+		wantErr bool
+	}{
+		{name: "default class", rate: 250 * time.Millisecond},
+		{name: "configured class", class: "slow", rate: 5 * time.Second},
+		{name: "unknown class", class: "fast", rate: time.Second, wantErr: true},
+		{name: "zero rate", rate: 0, wantErr: true},
+		{name: "negative rate", class: "slow", rate: -time.Second, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, NewLoopback(), Config{ScanRate: time.Second, ScanClasses: map[string]time.Duration{"slow": time.Minute}})
+			connectTestClient(t, c)
+			before, _ := c.ScanRate(tt.class)
+
+			err := c.SetScanRate(tt.class, tt.rate)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetScanRate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			want := tt.rate
+			if tt.wantErr {
+				want = before
+			}
+			if got, _ := c.ScanRate(tt.class); got != want {
+				t.Errorf("ScanRate(%q) = %s, want %s", tt.class, got, want)
+			}
+
+			wantMetric := time.Second.Milliseconds()
+			if tt.class == "" && !tt.wantErr {
+				wantMetric = tt.rate.Milliseconds()
+			}
+			if got, _ := c.NodeMetrics().Value(scanRateMetric); got != wantMetric {
+				t.Errorf("%s metric = %v, want %d", scanRateMetric, got, wantMetric)
+			}
+		})
+	}
+}
+
+func TestScanRateCommand(t *testing.T) {
+	loop := NewLoopback()
+	_, events := newLoopbackHost(t, loop)
+	c := newTestClient(t, loop, Config{ScanRate: time.Hour})
+
+	pump := &testDevice{id: "pump", values: map[string]any{"speed": 1200.0}}
+	if err := c.AddDevice(pump); err != nil {
+		t.Fatal(err)
+	}
+
+	connectTestClient(t, c)
+	birth := waitForEvent(t, events, MessageTypeNBIRTH)
+	if metric, ok := findMetric(birth.payload, scanRateMetric); !ok || metric.GetLongValue() != uint64(time.Hour.Milliseconds()) {
+		t.Errorf("NBIRTH %s = %v, want %d", scanRateMetric, metric, time.Hour.Milliseconds())
+	}
+	waitForEvent(t, events, MessageTypeDBIRTH)
+
+	publishCommand(t, loop, "spBv1.0/plant/NCMD/edge-1", commandMetric(t, scanRateMetric, sproto.DataType_Int64, int64(-5)))
+	waitUntil(t, "the invalid scan rate is rejected", func() bool { return c.RejectedWrites() == 1 })
+	expectNoEvent(t, events, MessageTypeNDATA, 50*time.Millisecond)
+	if rate, _ := c.ScanRate(""); rate != time.Hour {
+		t.Errorf("ScanRate() after a rejected command = %s, want 1h", rate)
+	}
+	if c.RejectedWrites() != 1 {
+		t.Errorf("RejectedWrites() = %d, want the rejection counted once", c.RejectedWrites())
+	}
+
+	if err := pump.SetMetricValue("speed", 1250.0); err != nil {
+		t.Fatal(err)
+	}
+	publishCommand(t, loop, "spBv1.0/plant/NCMD/edge-1", commandMetric(t, scanRateMetric, sproto.DataType_Int64, int64(20)))
+
+	echo := waitForEvent(t, events, MessageTypeNDATA)
+	if metric, ok := findMetric(echo.payload, scanRateMetric); !ok || metric.GetLongValue() != 20 {
+		t.Errorf("NDATA echo = %v, want %s 20", echo.payload.Metrics, scanRateMetric)
+	}
+	if rate, _ := c.ScanRate(""); rate != 20*time.Millisecond {
+		t.Errorf("ScanRate() = %s, want 20ms", rate)
+	}
+
+	scanned := waitForEvent(t, events, MessageTypeDDATA)
+	if metric, ok := findMetric(scanned.payload, "speed"); scanned.deviceID != "pump" || !ok || metric.GetDoubleValue() != 1250 {
+		t.Errorf("scanned DDATA for %s = %v, want pump speed 1250", scanned.deviceID, scanned.payload.Metrics)
+	}
+}
+
+func TestScanClasses(t *testing.T) {
+	const slowRate = 300 * time.Millisecond
+
+	loop := NewLoopback()
+	_, events := newLoopbackHost(t, loop)
+	c := newTestClient(t, loop, Config{ScanRate: time.Hour, ScanClasses: map[string]time.Duration{"fast": 20 * time.Millisecond, "slow": slowRate}})
+
+	registry := c.DeviceMetrics("pump")
+	for _, definition := range []MetricDefinition{
+		{Name: "speed", DataType: sproto.DataType_Double, ScanClass: "fast"},
+		{Name: "temperature", DataType: sproto.DataType_Double, ScanClass: "slow"},
+	} {
+		if _, err := registry.Declare(definition); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pump := &testDevice{id: "pump", values: map[string]any{"speed": 1200.0, "temperature": 20.0}}
+	if err := c.AddDevice(pump); err != nil {
+		t.Fatal(err)
+	}
+	connectTestClient(t, c)
+	waitForEvent(t, events, MessageTypeDBIRTH)
+
+	pump.SetMetricValue("temperature", 21.0)
+	waitForMetric(t, events, "temperature")
+	first := time.Now()
+
+	pump.SetMetricValue("temperature", 22.0)
+	pump.SetMetricValue("speed", 1250.0)
+
+	fast := waitForEvent(t, events, MessageTypeDDATA)
+	if _, ok := findMetric(fast.payload, "temperature"); ok {
+		t.Errorf("fast scan DDATA = %v, want no temperature", fast.payload.Metrics)
+	}
+	if metric, ok := findMetric(fast.payload, "speed"); !ok || metric.GetDoubleValue() != 1250 {
+		t.Errorf("fast scan DDATA = %v, want speed 1250", fast.payload.Metrics)
+	}
+
+	slow := waitForMetric(t, events, "temperature")
+	if metric, _ := findMetric(slow.payload, "temperature"); metric.GetDoubleValue() != 22 {
+		t.Errorf("slow scan DDATA = %v, want temperature 22", slow.payload.Metrics)
+	}
+	if elapsed := time.Since(first); elapsed < slowRate*2/3 {
+		t.Errorf("slow scans %s apart, want about %s", elapsed, slowRate)
+	}
+}
+
+func waitForMetric(t *testing.T, events <-chan hostEvent, name string) hostEvent {
+	t.Helper()
+
+	for {
+		event := waitForEvent(t, events, MessageTypeDDATA)
+		if _, ok := findMetric(event.payload, name); ok {
+			return event
+		}
+	}
+}
+
+func TestScanRateReadOnConnect(t *testing.T) {
+	loop := NewLoopback()
+	_, events := newLoopbackHost(t, loop)
+	c := newTestClient(t, loop, Config{})
+	c.Config.ScanRate = time.Hour
+
+	connectTestClient(t, c)
+	birth := waitForEvent(t, events, MessageTypeNBIRTH)
+	if metric, ok := findMetric(birth.payload, scanRateMetric); !ok || metric.GetLongValue() != uint64(time.Hour.Milliseconds()) {
+		t.Errorf("NBIRTH = %v, want %s 3600000", birth.payload.Metrics, scanRateMetric)
+	}
+
+	if err := c.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	c.Config.ScanRate = 2 * time.Second
+
+	connectTestClient(t, c)
+	birth = waitForEvent(t, events, MessageTypeNBIRTH)
+	if metric, ok := findMetric(birth.payload, scanRateMetric); !ok || metric.GetLongValue() != 2000 {
+		t.Errorf("NBIRTH after reconnecting = %v, want %s 2000", birth.payload.Metrics, scanRateMetric)
+	}
+	if rate, _ := c.ScanRate(""); rate != 2*time.Second {
+		t.Errorf("ScanRate() = %s, want 2s", rate)
+	}
+}
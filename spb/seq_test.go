@@ -0,0 +1,66 @@
+package spb
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentPublishSeq(t *testing.T) {
+	const (
+		publishers = 8
+		perWorker  = 40
+	)
+
+	loop := NewLoopback()
+	wire := sniff(t, loop, "spBv1.0/plant/+/edge-1/#", 2+publishers*perWorker)
+	c := newTestClient(t, loop, Config{})
+
+	device := &testDevice{id: "pump", values: map[string]any{"speed": int64(0)}}
+	if err := c.AddDevice(device); err != nil {
+		t.Fatal(err)
+	}
+	connectTestClient(t, c)
+	waitUntil(t, "the device is born", func() bool { return c.IsDeviceBorn("pump") })
+
+	var wg sync.WaitGroup
+	errs := make(chan error, publishers*perWorker)
+	for w := 0; w < publishers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				errs <- c.PublishDDATA(device, map[string]any{"speed": int64(w*perWorker + i)})
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("PublishDDATA() error = %v", err)
+		}
+	}
+
+	var seqs []uint64
+	deadline := time.After(loopbackTimeout)
+	for len(seqs) < 2+publishers*perWorker {
+		select {
+		case msg := <-wire:
+			payload, err := unmarshalPayload(msg.Payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seqs = append(seqs, payload.GetSeq())
+		case <-deadline:
+			t.Fatalf("timed out after %d messages", len(seqs))
+		}
+	}
+
+	for i, seq := range seqs {
+		if want := uint64(i % 256); seq != want {
+			t.Fatalf("message %d seq = %d, want %d", i, seq, want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package spb
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type State struct {
+	Online    bool  `json:"online"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+type StateHandler func(hostID string, state State)
+
+func newState(online bool, timestamp time.Time) State {
+	return State{
+		Online:    online,
+		Timestamp: timestamp.UnixMilli(),
+	}
+}
+
+func buildStatePayload(state State) ([]byte, error) {
+	payloadBytes, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal STATE payload: %w", err)
+	}
+
+	return payloadBytes, nil
+}
+
+func parseStatePayload(payloadBytes []byte) (State, error) {
+	var state State
+	if err := json.Unmarshal(payloadBytes, &state); err != nil {
+		return State{}, fmt.Errorf("failed to unmarshal STATE payload: %w", err)
+	}
+
+	return state, nil
+}
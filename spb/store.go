@@ -0,0 +1,273 @@
+package spb
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrStoreFull = errors.New("message store is full")
+
+type DropPolicy int
+
+const (
+	DropOldest DropPolicy = iota
+	DropNewest
+)
+
+type StoreLimits struct {
+	MaxMessages int
+	MaxBytes    int
+	Policy      DropPolicy
+}
+
+type StoredMessage struct {
+	Topic   string
+	Payload []byte
+}
+
+func (m StoredMessage) size() int {
+	return len(m.Topic) + len(m.Payload)
+}
+
+type MessageStore interface {
+	Push(msg StoredMessage) error
+	Peek() (StoredMessage, bool, error)
+	Pop() error
+	Len() int
+}
+
+func (l StoreLimits) exceeded(messages, bytes int) bool {
+	if l.MaxMessages > 0 && messages > l.MaxMessages {
+		return true
+	}
+
+	return l.MaxBytes > 0 && bytes > l.MaxBytes
+}
+
+type MemoryStore struct {
+	mu       sync.Mutex
+	limits   StoreLimits
+	messages []StoredMessage
+	head     int
+	count    int
+	bytes    int
+	dropped  uint64
+}
+
+func NewMemoryStore(limits StoreLimits) *MemoryStore {
+	capacity := limits.MaxMessages
+	if capacity <= 0 {
+		capacity = 64
+	}
+
+	return &MemoryStore{
+		limits:   limits,
+		messages: make([]StoredMessage, capacity),
+	}
+}
+
+func (s *MemoryStore) Push(msg StoredMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.limits.exceeded(1, msg.size()) {
+		s.dropped++
+		return ErrStoreFull
+	}
+
+	for s.limits.exceeded(s.count+1, s.bytes+msg.size()) {
+		if s.limits.Policy == DropNewest {
+			s.dropped++
+			return ErrStoreFull
+		}
+		s.pop()
+		s.dropped++
+	}
+
+	if s.count == len(s.messages) {
+		s.grow()
+	}
+
+	s.messages[(s.head+s.count)%len(s.messages)] = msg
+	s.count++
+	s.bytes += msg.size()
+
+	return nil
+}
+
+func (s *MemoryStore) Peek() (StoredMessage, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.count == 0 {
+		return StoredMessage{}, false, nil
+	}
+
+	return s.messages[s.head], true, nil
+}
+
+func (s *MemoryStore) Pop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pop()
+
+	return nil
+}
+
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+func (s *MemoryStore) Dropped() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+func (s *MemoryStore) pop() {
+	if s.count == 0 {
+		return
+	}
+
+	s.bytes -= s.messages[s.head].size()
+	s.messages[s.head] = StoredMessage{}
+	s.head = (s.head + 1) % len(s.messages)
+	s.count--
+}
+
+func (s *MemoryStore) grow() {
+	messages := make([]StoredMessage, 2*len(s.messages))
+	for i := 0; i < s.count; i++ {
+		messages[i] = s.messages[(s.head+i)%len(s.messages)]
+	}
+
+	s.messages = messages
+	s.head = 0
+}
+
+func (c *Client) shouldStore() bool {
+	if c.Config.Store == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	born := c.born
+	c.mu.Unlock()
+
+	return !born || !c.isConnected()
+}
+
+func (c *Client) storeMessage(topic string, payload *sproto.Payload) error {
+	parsed, err := ParseTopic(topic)
+	if err != nil {
+		return fmt.Errorf("failed to store message: %w", err)
+	}
+
+	for _, metric := range payload.Metrics {
+		if c.aliases.resolveMetric(parsed.DeviceID, metric) && metric.Name != nil {
+			metric.Alias = nil
+		}
+	}
+
+	payloadBytes, err := proto.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal stored payload: %w", err)
+	}
+
+	if err := c.Config.Store.Push(StoredMessage{Topic: topic, Payload: payloadBytes}); err != nil {
+		return fmt.Errorf("failed to store message for topic %s: %w", topic, err)
+	}
+
+	log.Printf("Stored message for topic %s while offline", topic)
+
+	return nil
+}
+
+func (c *Client) replayStored() {
+	store := c.Config.Store
+	if store == nil || !c.replaying.CompareAndSwap(false, true) {
+		return
+	}
+	defer c.replaying.Store(false)
+
+	replayed := 0
+	for {
+		msg, ok, err := store.Peek()
+		if err != nil {
+			log.Printf("Failed to read stored message: %v", err)
+			return
+		}
+		if !ok {
+			break
+		}
+
+		if err := c.replayMessage(msg); err != nil {
+			log.Printf("Failed to replay stored message for topic %s: %v", msg.Topic, err)
+			return
+		}
+
+		if err := store.Pop(); err != nil {
+			log.Printf("Failed to remove replayed message: %v", err)
+			return
+		}
+		replayed++
+	}
+
+	if replayed > 0 {
+		log.Printf("Replayed %d stored messages", replayed)
+	}
+}
+
+func (c *Client) replayMessage(msg StoredMessage) error {
+	topic, err := ParseTopic(msg.Topic)
+	if err != nil {
+		log.Printf("Dropping stored message with invalid topic: %v", err)
+		return nil
+	}
+
+	if topic.DeviceID != "" && !c.IsDeviceBorn(topic.DeviceID) {
+		log.Printf("Dropping stored message for device %s that is no longer born", topic.DeviceID)
+		return nil
+	}
+
+	payload, err := unmarshalPayload(msg.Payload)
+	if err != nil {
+		log.Printf("Dropping stored message that cannot be decoded: %v", err)
+		return nil
+	}
+
+	metrics := payload.Metrics[:0]
+	for _, metric := range payload.Metrics {
+		if metric.Name != nil {
+			alias, ok := c.aliases.lookup(topic.DeviceID, metric.GetName())
+			if !ok {
+				log.Printf("Dropping stored value of metric %s that is no longer declared", metric.GetName())
+				continue
+			}
+			metric.Name = nil
+			metric.Alias = proto.Uint64(alias)
+		}
+
+		metric.IsHistorical = proto.Bool(true)
+		metrics = append(metrics, metric)
+	}
+	payload.Metrics = metrics
+
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	if !c.isConnected() {
+		return fmt.Errorf("MQTT client is not connected")
+	}
+
+	return c.publish(topic.MessageType, msg.Topic, payload, false, nil)
+}
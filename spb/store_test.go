@@ -0,0 +1,310 @@
+package spb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+)
+
+type droppingStore interface {
+	MessageStore
+	Dropped() uint64
+}
+
+func storedTopics(t *testing.T, store MessageStore) []string {
+	t.Helper()
+
+	var topics []string
+	for {
+		msg, ok, err := store.Peek()
+		if err != nil {
+			t.Fatalf("Peek() error = %v", err)
+		}
+		if !ok {
+			return topics
+		}
+		topics = append(topics, msg.Topic)
+		if err := store.Pop(); err != nil {
+			t.Fatalf("Pop() error = %v", err)
+		}
+	}
+}
+
+func openFileStore(t *testing.T, path string, limits StoreLimits) *FileStore {
+	t.Helper()
+
+	store, err := NewFileStore(path, limits)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+func TestStoreLimits(t *testing.T) {
+	stores := map[string]func(t *testing.T, limits StoreLimits) droppingStore{
+		"memory": func(t *testing.T, limits StoreLimits) droppingStore {
+			return NewMemoryStore(limits)
+		},
+		"file": func(t *testing.T, limits StoreLimits) droppingStore {
+			return openFileStore(t, filepath.Join(t.TempDir(), "store"), limits)
+		},
+	}
+
+	tests := []struct {
+		name    string
+		limits  StoreLimits
+		want    []string
+		dropped uint64
+		full    int
+	}{
+		{
+			name:    "message limit drop oldest",
+			limits:  StoreLimits{MaxMessages: 2, Policy: DropOldest},
+			want:    []string{"t2", "t3"},
+			dropped: 2,
+		},
+		{
+			name:    "message limit drop newest",
+			limits:  StoreLimits{MaxMessages: 2, Policy: DropNewest},
+			want:    []string{"t0", "t1"},
+			dropped: 2,
+			full:    2,
+		},
+		{
+			name:    "byte limit drop oldest",
+			limits:  StoreLimits{MaxBytes: 20, Policy: DropOldest},
+			want:    []string{"t1", "t2", "t3"},
+			dropped: 1,
+		},
+		{
+			name:    "byte limit drop newest",
+			limits:  StoreLimits{MaxBytes: 20, Policy: DropNewest},
+			want:    []string{"t0", "t1", "t2"},
+			dropped: 1,
+			full:    1,
+		},
+	}
+
+	for kind, newStore := range stores {
+		for _, tt := range tests {
+			t.Run(kind+" "+tt.name, func(t *testing.T) {
+				store := newStore(t, tt.limits)
+
+				full := 0
+				for i := 0; i < 4; i++ {
+					err := store.Push(StoredMessage{Topic: fmt.Sprintf("t%d", i), Payload: []byte("abcd")})
+					if errors.Is(err, ErrStoreFull) {
+						full++
+					} else if err != nil {
+						t.Fatalf("Push(%d) error = %v", i, err)
+					}
+				}
+
+				if full != tt.full {
+					t.Errorf("Push() returned ErrStoreFull %d times, want %d", full, tt.full)
+				}
+				if store.Dropped() != tt.dropped {
+					t.Errorf("Dropped() = %d, want %d", store.Dropped(), tt.dropped)
+				}
+				if got := storedTopics(t, store); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("stored topics = %v, want %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestStoreRejectsOversizedMessage(t *testing.T) {
+	limits := StoreLimits{MaxBytes: 8}
+	for name, store := range map[string]droppingStore{
+		"memory": NewMemoryStore(limits),
+		"file":   openFileStore(t, filepath.Join(t.TempDir(), "store"), limits),
+	} {
+		if err := store.Push(StoredMessage{Topic: "t", Payload: make([]byte, 8)}); !errors.Is(err, ErrStoreFull) {
+			t.Errorf("%s Push() error = %v, want ErrStoreFull", name, err)
+		}
+		if store.Len() != 0 || store.Dropped() != 1 {
+			t.Errorf("%s Len() = %d, Dropped() = %d, want 0 and 1", name, store.Len(), store.Dropped())
+		}
+	}
+}
+
+func TestFileStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+
+	store, err := NewFileStore(path, StoreLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, topic := range []string{"t0", "t1", "t2"} {
+		if err := store.Push(StoredMessage{Topic: topic, Payload: []byte(topic + " payload")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte{64, 0, 0, 0, 2, 0, 't', '3'}); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	reloaded := openFileStore(t, path, StoreLimits{})
+	if reloaded.Len() != 2 {
+		t.Fatalf("Len() after reload = %d, want 2", reloaded.Len())
+	}
+
+	msg, ok, err := reloaded.Peek()
+	if err != nil || !ok || msg.Topic != "t1" || string(msg.Payload) != "t1 payload" {
+		t.Errorf("Peek() after reload = %+v, %t, %v, want t1 from the stored head offset", msg, ok, err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != reloaded.end {
+		t.Errorf("file size = %d, want the torn record truncated to %d", info.Size(), reloaded.end)
+	}
+
+	if err := reloaded.Push(StoredMessage{Topic: "t4", Payload: []byte("t4 payload")}); err != nil {
+		t.Fatal(err)
+	}
+	if got := storedTopics(t, reloaded); !reflect.DeepEqual(got, []string{"t1", "t2", "t4"}) {
+		t.Errorf("stored topics = %v, want [t1 t2 t4]", got)
+	}
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+	store := openFileStore(t, path, StoreLimits{})
+
+	payload := []byte(strings.Repeat("x", fileStoreCompactMin/2))
+	for _, topic := range []string{"t0", "t1", "t2", "t3"} {
+		if err := store.Push(StoredMessage{Topic: topic, Payload: payload}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := store.Pop(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Stat(.tmp) error = %v, want the temporary file renamed away", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(fileStoreHeaderSize + fileStoreRecordSize + 2 + len(payload)); info.Size() != want {
+		t.Errorf("file size after compaction = %d, want %d", info.Size(), want)
+	}
+
+	if err := store.Push(StoredMessage{Topic: "t4", Payload: []byte("tail")}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	reloaded := openFileStore(t, path, StoreLimits{})
+	if got := storedTopics(t, reloaded); !reflect.DeepEqual(got, []string{"t3", "t4"}) {
+		t.Errorf("stored topics after compaction and reload = %v, want [t3 t4]", got)
+	}
+}
+
+func TestReplayStoredAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+	loop := NewLoopback()
+
+	offline := newTestClient(t, loop, Config{Store: openFileStore(t, path, StoreLimits{})})
+	offline.NodeMetrics().Declare(MetricDefinition{Name: "Pressure", DataType: sproto.DataType_Double})
+	offline.NodeMetrics().Declare(MetricDefinition{Name: "Temperature", DataType: sproto.DataType_Double})
+	offline.DeviceMetrics("meter").Declare(MetricDefinition{Name: "energy", DataType: sproto.DataType_UInt64})
+	meter := &testDevice{id: "meter", values: map[string]any{"energy": uint64(100)}}
+	if err := offline.AddDevice(meter); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := offline.PublishNDATA(map[string]any{"Pressure": 1.5, "Temperature": 21.5}); err != nil {
+		t.Fatalf("PublishNDATA() while offline error = %v", err)
+	}
+	if err := offline.PublishDDATA(meter, map[string]any{"energy": uint64(101)}); err != nil {
+		t.Fatalf("PublishDDATA() while offline error = %v", err)
+	}
+	offline.Config.Store.(*FileStore).Close()
+
+	_, events := newLoopbackHost(t, loop)
+	wire := sniff(t, loop, "spBv1.0/plant/+/edge-1/#", 16)
+
+	store := openFileStore(t, path, StoreLimits{})
+	c := newTestClient(t, loop, Config{Store: store})
+	c.NodeMetrics().Declare(MetricDefinition{Name: "Temperature", DataType: sproto.DataType_Double})
+	if err := c.AddDevice(&testDevice{id: "meter", values: map[string]any{"energy": uint64(100)}}); err != nil {
+		t.Fatal(err)
+	}
+	connectTestClient(t, c)
+
+	nbirth := waitForEvent(t, events, MessageTypeNBIRTH)
+	dbirth := waitForEvent(t, events, MessageTypeDBIRTH)
+	waitUntil(t, "the store is replayed", func() bool { return store.Len() == 0 })
+
+	aliases := map[string]uint64{}
+	for _, birth := range []hostEvent{nbirth, dbirth} {
+		for _, metric := range birth.payload.Metrics {
+			aliases[birth.deviceID+"/"+metric.GetName()] = metric.GetAlias()
+		}
+	}
+
+	replayed := map[string][]*sproto.Payload_Metric{}
+	deadline := time.After(loopbackTimeout)
+	for len(replayed) < 2 {
+		select {
+		case msg := <-wire:
+			topic, err := ParseTopic(msg.Topic)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if topic.MessageType != MessageTypeNDATA && topic.MessageType != MessageTypeDDATA {
+				continue
+			}
+			payload, err := unmarshalPayload(msg.Payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			replayed[topic.DeviceID] = payload.Metrics
+		case <-events:
+		case <-deadline:
+			t.Fatalf("timed out waiting for replayed data, got %v", replayed)
+		}
+	}
+
+	wantAliases := map[string]uint64{"": aliases["/Temperature"], "meter": aliases["meter/energy"]}
+	for deviceID, metrics := range replayed {
+		if len(metrics) != 1 {
+			t.Errorf("replayed metrics for %q = %v, want only the still declared metric", deviceID, metrics)
+			continue
+		}
+
+		metric := metrics[0]
+		if metric.Name != nil || metric.GetAlias() != wantAliases[deviceID] || !metric.GetIsHistorical() {
+			t.Errorf("replayed metric for %q = %v, want historical alias %d without a name", deviceID, metric, wantAliases[deviceID])
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package spb
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+)
+
+type DefinedDevice interface {
+	Device
+	MetricDefinitions() []MetricDefinition
+}
+
+type StructDevice struct {
+	id     string
+	mu     sync.Mutex
+	fields []structField
+	byName map[string]*structField
+}
+
+type structField struct {
+	definition MetricDefinition
+	value      reflect.Value
+}
+
+type StructTagError struct {
+	Field  string
+	Tag    string
+	Reason string
+}
+
+func (e *StructTagError) Error() string {
+	return fmt.Sprintf("invalid spb tag %q on field %s: %s", e.Tag, e.Field, e.Reason)
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	numericGoTypes = map[sproto.DataType]reflect.Type{
+		sproto.DataType_Int8:   reflect.TypeOf(int8(0)),
+		sproto.DataType_Int16:  reflect.TypeOf(int16(0)),
+		sproto.DataType_Int32:  reflect.TypeOf(int32(0)),
+		sproto.DataType_Int64:  reflect.TypeOf(int64(0)),
+		sproto.DataType_UInt8:  reflect.TypeOf(uint8(0)),
+		sproto.DataType_UInt16: reflect.TypeOf(uint16(0)),
+		sproto.DataType_UInt32: reflect.TypeOf(uint32(0)),
+		sproto.DataType_UInt64: reflect.TypeOf(uint64(0)),
+		sproto.DataType_Float:  reflect.TypeOf(float32(0)),
+		sproto.DataType_Double: reflect.TypeOf(float64(0)),
+	}
+)
+
+func NewStructDevice(id string, model any) (*StructDevice, error) {
+	v := reflect.ValueOf(model)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("device model must be a non-nil pointer to a struct, got %T", model)
+	}
+
+	d := &StructDevice{id: id, byName: make(map[string]*structField)}
+	if err := d.addFields(v.Elem(), ""); err != nil {
+		return nil, err
+	}
+
+	if len(d.fields) == 0 {
+		return nil, fmt.Errorf("device model %T has no fields with an spb tag", model)
+	}
+
+	for i := range d.fields {
+		d.byName[d.fields[i].definition.Name] = &d.fields[i]
+	}
+
+	return d, nil
+}
+
+func (d *StructDevice) addFields(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("spb")
+		if !ok || tag == "-" {
+			continue
+		}
+
+		if !field.IsExported() {
+			return &StructTagError{Field: field.Name, Tag: tag, Reason: "field is not exported"}
+		}
+
+		definition, err := parseStructTag(field, tag)
+		if err != nil {
+			return err
+		}
+		definition.Name = prefix + definition.Name
+
+		value := v.Field(i)
+		if value.Kind() == reflect.Struct && value.Type() != timeType && definition.DataType == sproto.DataType_Unknown {
+			if err := d.addFields(value, definition.Name+"/"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, ok := d.byName[definition.Name]; ok {
+			return &StructTagError{Field: field.Name, Tag: tag, Reason: fmt.Sprintf("metric %s is declared twice", definition.Name)}
+		}
+		d.byName[definition.Name] = nil
+
+		if definition.DataType == sproto.DataType_Unknown {
+			dataType, ok := inferDataType(value.Interface())
+			if !ok {
+				return &StructTagError{Field: field.Name, Tag: tag, Reason: fmt.Sprintf("cannot infer a datatype for %s", value.Type())}
+			}
+			definition.DataType = dataType
+		}
+
+		f := structField{definition: definition, value: value}
+		if _, ok := encodeValue(definition.DataType, f.get()); !ok {
+			return &StructTagError{Field: field.Name, Tag: tag, Reason: fmt.Sprintf("%s cannot hold datatype %s", value.Type(), definition.DataType)}
+		}
+
+		d.fields = append(d.fields, f)
+	}
+
+	return nil
+}
+
+func parseStructTag(field reflect.StructField, tag string) (MetricDefinition, error) {
+	parts := strings.Split(tag, ",")
+	definition := MetricDefinition{Name: strings.TrimSpace(parts[0])}
+	if definition.Name == "" {
+		definition.Name = field.Name
+	}
+
+	for _, option := range parts[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+		invalid := func(reason string) error {
+			return &StructTagError{Field: field.Name, Tag: tag, Reason: reason}
+		}
+
+		switch key {
+		case "type":
+			dataType, ok := sproto.DataType_value[value]
+			if !ok || dataType == int32(sproto.DataType_Unknown) {
+				return MetricDefinition{}, invalid(fmt.Sprintf("unknown datatype %s", value))
+			}
+			definition.DataType = sproto.DataType(dataType)
+
+		case "unit", "description":
+			property := PropertyEngUnit
+			if key == "description" {
+				property = PropertyDescription
+			}
+			if definition.Properties == nil {
+				definition.Properties = PropertySet{}
+			}
+			definition.Properties[property] = PropertyValue{DataType: sproto.DataType_String, Value: value}
+
+		case "writable":
+			definition.Writable = true
+
+		case "deadband", "deadbandPercent":
+			deadband, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return MetricDefinition{}, invalid(fmt.Sprintf("invalid %s %s", key, value))
+			}
+			if key == "deadband" {
+				definition.Deadband = deadband
+			} else {
+				definition.DeadbandPercent = deadband
+			}
+
+		case "scan":
+			definition.ScanClass = value
+
+		case "":
+
+		default:
+			return MetricDefinition{}, invalid(fmt.Sprintf("unknown option %s", key))
+		}
+	}
+
+	return definition, nil
+}
+
+func (d *StructDevice) GetId() string {
+	return d.id
+}
+
+func (d *StructDevice) MetricDefinitions() []MetricDefinition {
+	definitions := make([]MetricDefinition, 0, len(d.fields))
+	for _, f := range d.fields {
+		definitions = append(definitions, f.definition)
+	}
+
+	return definitions
+}
+
+func (d *StructDevice) GetMetricValues() map[string]any {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	values := make(map[string]any, len(d.fields))
+	for _, f := range d.fields {
+		values[f.definition.Name] = f.get()
+	}
+
+	return values
+}
+
+func (d *StructDevice) SetMetricValue(name string, value any) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f, ok := d.byName[name]
+	if !ok {
+		return fmt.Errorf("device %s has no metric %s", d.id, name)
+	}
+
+	if !f.definition.Writable {
+		return fmt.Errorf("metric %s is not writable", name)
+	}
+
+	return f.set(value)
+}
+
+func (d *StructDevice) Update(fn func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fn()
+}
+
+func (f *structField) get() any {
+	goType, numeric := numericGoTypes[f.definition.DataType]
+	if numeric && goType.Kind() != reflect.Float32 && goType.Kind() != reflect.Float64 {
+		switch {
+		case f.value.CanInt():
+			return f.value.Int()
+		case f.value.CanUint():
+			return f.value.Uint()
+		}
+	}
+
+	if numeric && f.value.CanConvert(goType) {
+		return f.value.Convert(goType).Interface()
+	}
+
+	return f.value.Interface()
+}
+
+func (f *structField) set(value any) error {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		return fmt.Errorf("cannot write a null value to metric %s", f.definition.Name)
+	}
+
+	target := f.value.Type()
+	if v.Type().AssignableTo(target) {
+		f.value.Set(v)
+		return nil
+	}
+
+	if _, numeric := numericGoTypes[f.definition.DataType]; !numeric || !v.CanConvert(target) {
+		return fmt.Errorf("cannot write %T to metric %s of type %s", value, f.definition.Name, target)
+	}
+
+	converted := v.Convert(target)
+	if !converted.Convert(v.Type()).Equal(v) {
+		return fmt.Errorf("value %v is out of range for metric %s of type %s", value, f.definition.Name, target)
+	}
+
+	f.value.Set(converted)
+
+	return nil
+}
@@ -0,0 +1,234 @@
+package spb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+)
+
+func TestParseStructTag(t *testing.T) {
+	tests := []struct {
+		name   string
+		tag    string
+		want   MetricDefinition
+		reason string
+	}{
+		{
+			name: "all options",
+			tag:  "Motor/Speed,type=Float,unit=rpm,writable,deadband=0.5,scan=fast",
+			want: MetricDefinition{
+				Name:       "Motor/Speed",
+				DataType:   sproto.DataType_Float,
+				Properties: PropertySet{PropertyEngUnit: {DataType: sproto.DataType_String, Value: "rpm"}},
+				Writable:   true,
+				Deadband:   0.5,
+				ScanClass:  "fast",
+			},
+		},
+		{
+			name: "field name and description",
+			tag:  ",description=Motor speed,deadbandPercent=2",
+			want: MetricDefinition{
+				Name:            "Speed",
+				Properties:      PropertySet{PropertyDescription: {DataType: sproto.DataType_String, Value: "Motor speed"}},
+				DeadbandPercent: 2,
+			},
+		},
+		{name: "spaces and empty options", tag: " Speed , writable ,", want: MetricDefinition{Name: "Speed", Writable: true}},
+		{name: "unknown datatype", tag: "Speed,type=Floaty", reason: "unknown datatype Floaty"},
+		{name: "unknown datatype name", tag: "Speed,type=Unknown", reason: "unknown datatype Unknown"},
+		{name: "bad deadband", tag: "Speed,deadband=fast", reason: "invalid deadband fast"},
+		{name: "empty deadband percent", tag: "Speed,deadbandPercent=", reason: "invalid deadbandPercent "},
+		{name: "unknown option", tag: "Speed,color=red", reason: "unknown option color"},
+	}
+
+	field := reflect.StructField{Name: "Speed", Type: reflect.TypeOf(0.0)}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseStructTag(field, tt.tag)
+			if tt.reason != "" {
+				var tagErr *StructTagError
+				if !errors.As(err, &tagErr) || tagErr.Field != "Speed" || tagErr.Tag != tt.tag || tagErr.Reason != tt.reason {
+					t.Fatalf("parseStructTag() error = %#v, want reason %q", err, tt.reason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseStructTag() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseStructTag() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewStructDeviceErrors(t *testing.T) {
+	type limits struct {
+		High float64 `spb:"High,type=Bogus"`
+	}
+
+	tests := []struct {
+		name   string
+		model  any
+		field  string
+		tagErr bool
+	}{
+		{name: "not a pointer", model: struct {
+			Speed float64 `spb:"Speed"`
+		}{}},
+		{name: "nil pointer", model: (*limits)(nil)},
+		{name: "pointer to non-struct", model: new(int)},
+		{name: "no tagged fields", model: &struct{ Speed float64 }{}},
+		{name: "unexported field", model: &struct {
+			speed float64 `spb:"Speed"`
+		}{}, field: "speed", tagErr: true},
+		{name: "unsupported type", model: &struct {
+			Events chan int `spb:"Events"`
+		}{}, field: "Events", tagErr: true},
+		{name: "type cannot hold datatype", model: &struct {
+			Speed string `spb:"Speed,type=Float"`
+		}{}, field: "Speed", tagErr: true},
+		{name: "duplicate metric", model: &struct {
+			Speed  float64 `spb:"Speed"`
+			Speed2 float64 `spb:"Speed"`
+		}{}, field: "Speed2", tagErr: true},
+		{name: "bad tag in nested struct", model: &struct {
+			Limits limits `spb:"Limits"`
+		}{}, field: "High", tagErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewStructDevice("motor", tt.model)
+			if err == nil {
+				t.Fatal("NewStructDevice() error = nil, want an error")
+			}
+
+			var tagErr *StructTagError
+			if errors.As(err, &tagErr) != tt.tagErr {
+				t.Fatalf("NewStructDevice() error = %v, want StructTagError %t", err, tt.tagErr)
+			}
+			if tt.tagErr && tagErr.Field != tt.field {
+				t.Errorf("StructTagError.Field = %s, want %s", tagErr.Field, tt.field)
+			}
+		})
+	}
+}
+
+func TestStructDevice(t *testing.T) {
+	type motor struct {
+		Speed   float64 `spb:"Speed,type=Float,writable"`
+		Running bool    `spb:"Running"`
+		Limits  struct {
+			High int16 `spb:"High,writable"`
+		} `spb:"Limits"`
+		Started  time.Time `spb:"Started"`
+		Internal string    `spb:"-"`
+		Notes    string
+	}
+
+	model := &motor{Speed: 1420, Started: time.UnixMilli(1700000000000)}
+	model.Limits.High = 1500
+
+	d, err := NewStructDevice("motor", model)
+	if err != nil {
+		t.Fatalf("NewStructDevice() error = %v", err)
+	}
+
+	var names []string
+	for _, definition := range d.MetricDefinitions() {
+		names = append(names, definition.Name+"="+definition.DataType.String())
+	}
+	if want := []string{"Speed=Float", "Running=Boolean", "Limits/High=Int16", "Started=DateTime"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("MetricDefinitions() = %v, want %v", names, want)
+	}
+
+	if got := d.GetMetricValues()["Speed"]; got != float32(1420) {
+		t.Errorf("Speed value = %#v, want float32 1420", got)
+	}
+
+	tests := []struct {
+		name    string
+		metric  string
+		value   any
+		wantErr bool
+	}{
+		{name: "writable", metric: "Speed", value: float32(1450)},
+		{name: "nested writable", metric: "Limits/High", value: int64(1600)},
+		{name: "out of range", metric: "Limits/High", value: int64(40000), wantErr: true},
+		{name: "wrong type", metric: "Speed", value: "fast", wantErr: true},
+		{name: "read-only", metric: "Running", value: true, wantErr: true},
+		{name: "unknown metric", metric: "Notes", value: "x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := d.SetMetricValue(tt.metric, tt.value); (err != nil) != tt.wantErr {
+				t.Errorf("SetMetricValue(%s, %v) error = %v, wantErr %t", tt.metric, tt.value, err, tt.wantErr)
+			}
+		})
+	}
+
+	if model.Speed != 1450 || model.Limits.High != 1600 || model.Running {
+		t.Errorf("model = %+v, want Speed 1450, High 1600 and Running unchanged", model)
+	}
+}
+
+func TestStructDeviceIntegerRange(t *testing.T) {
+	type level int
+
+	type gauge struct {
+		Speed int    `spb:"Speed,type=Int8"`
+		Count uint64 `spb:"Count,type=Int64"`
+		Level level  `spb:"Level,type=UInt32"`
+	}
+
+	tests := []struct {
+		name   string
+		model  gauge
+		metric string
+	}{
+		{name: "in range", model: gauge{Speed: -128, Count: 1 << 62, Level: 4294967295}},
+		{name: "overflow", model: gauge{Speed: 300}, metric: "Speed"},
+		{name: "unsigned overflow into signed", model: gauge{Count: 1 << 63}, metric: "Count"},
+		{name: "negative unsigned", model: gauge{Level: -1}, metric: "Level"},
+		{name: "unsigned overflow", model: gauge{Level: 1 << 32}, metric: "Level"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := &gauge{}
+			d, err := NewStructDevice("gauge", model)
+			if err != nil {
+				t.Fatalf("NewStructDevice() error = %v", err)
+			}
+			d.Update(func() { *model = tt.model })
+
+			r := newMetricRegistry("gauge", newAliasTable(), newTemplateRegistry())
+			if err := r.declareAll(d.MetricDefinitions()); err != nil {
+				t.Fatal(err)
+			}
+
+			err = r.update(d.GetMetricValues())
+			if tt.metric == "" {
+				if err != nil {
+					t.Fatalf("update() error = %v", err)
+				}
+				if value, _ := r.Value("Speed"); value != int64(-128) {
+					t.Errorf("Speed value = %#v, want int64 -128", value)
+				}
+				return
+			}
+
+			var typeErr *MetricTypeError
+			if !errors.As(err, &typeErr) || typeErr.Name != tt.metric {
+				t.Fatalf("update() error = %v, want a MetricTypeError for %s", err, tt.metric)
+			}
+		})
+	}
+}
@@ -0,0 +1,407 @@
+package spb
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+type TemplateParameter struct {
+	Name     string
+	DataType sproto.DataType
+	Value    any
+}
+
+type TemplateMetric struct {
+	Name     string
+	DataType sproto.DataType
+	Value    any
+}
+
+type Template struct {
+	Version      string
+	TemplateRef  string
+	IsDefinition bool
+	Parameters   []TemplateParameter
+	Metrics      []TemplateMetric
+}
+
+func (t *Template) Metric(name string) (TemplateMetric, bool) {
+	for _, metric := range t.Metrics {
+		if metric.Name == name {
+			return metric, true
+		}
+	}
+
+	return TemplateMetric{}, false
+}
+
+func (t *Template) SetMetric(name string, value any) error {
+	for i := range t.Metrics {
+		if t.Metrics[i].Name != name {
+			continue
+		}
+
+		if value != nil {
+			if _, ok := encodeValue(t.Metrics[i].DataType, value); !ok {
+				return &MetricTypeError{Name: name, DataType: t.Metrics[i].DataType, Value: value}
+			}
+		}
+
+		t.Metrics[i].Value = value
+		return nil
+	}
+
+	return fmt.Errorf("template has no metric %s", name)
+}
+
+func (t *Template) SetParameter(name string, value any) error {
+	for i := range t.Parameters {
+		if t.Parameters[i].Name != name {
+			continue
+		}
+
+		if value != nil {
+			if _, ok := encodeDataSetValue(t.Parameters[i].DataType, value); !ok {
+				return &MetricTypeError{Name: name, DataType: t.Parameters[i].DataType, Value: value}
+			}
+		}
+
+		t.Parameters[i].Value = value
+		return nil
+	}
+
+	return fmt.Errorf("template has no parameter %s", name)
+}
+
+func (t *Template) clone() *Template {
+	c := *t
+	c.Parameters = append([]TemplateParameter(nil), t.Parameters...)
+	c.Metrics = make([]TemplateMetric, len(t.Metrics))
+	for i, metric := range t.Metrics {
+		if nested, ok := metric.Value.(*Template); ok && nested != nil {
+			metric.Value = nested.clone()
+		}
+		c.Metrics[i] = metric
+	}
+
+	return &c
+}
+
+func (t *Template) toProto() (*sproto.Payload_Template, error) {
+	template := &sproto.Payload_Template{
+		IsDefinition: proto.Bool(t.IsDefinition),
+	}
+
+	if t.Version != "" {
+		template.Version = proto.String(t.Version)
+	}
+
+	if !t.IsDefinition {
+		template.TemplateRef = proto.String(t.TemplateRef)
+	}
+
+	for _, parameter := range t.Parameters {
+		p := &sproto.Payload_Template_Parameter{
+			Name: proto.String(parameter.Name),
+			Type: proto.Uint32(uint32(parameter.DataType)),
+		}
+
+		if parameter.Value != nil && !encodeParameterValue(p, parameter.DataType, parameter.Value) {
+			return nil, &MetricTypeError{Name: parameter.Name, DataType: parameter.DataType, Value: parameter.Value}
+		}
+
+		template.Parameters = append(template.Parameters, p)
+	}
+
+	for _, member := range t.Metrics {
+		var metric *sproto.Payload_Metric
+		if member.Value == nil {
+			metric = newNullMetric(member.Name, member.DataType)
+		} else {
+			var err error
+			metric, err = NewMetric(member.Name, member.DataType, member.Value)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		template.Metrics = append(template.Metrics, metric)
+	}
+
+	return template, nil
+}
+
+func DecodeTemplate(template *sproto.Payload_Template) (*Template, error) {
+	t := &Template{
+		Version:      template.GetVersion(),
+		TemplateRef:  template.GetTemplateRef(),
+		IsDefinition: template.GetIsDefinition(),
+	}
+
+	for _, parameter := range template.GetParameters() {
+		dataType := sproto.DataType(parameter.GetType())
+		value, err := decodeParameterValue(dataType, parameter)
+		if err != nil {
+			return nil, fmt.Errorf("template parameter %s: %w", parameter.GetName(), err)
+		}
+
+		t.Parameters = append(t.Parameters, TemplateParameter{
+			Name:     parameter.GetName(),
+			DataType: dataType,
+			Value:    value,
+		})
+	}
+
+	for _, metric := range template.GetMetrics() {
+		value, err := MetricValue(metric)
+		if err != nil {
+			return nil, fmt.Errorf("template metric %s: %w", metric.GetName(), err)
+		}
+
+		t.Metrics = append(t.Metrics, TemplateMetric{
+			Name:     metric.GetName(),
+			DataType: sproto.DataType(metric.GetDatatype()),
+			Value:    value,
+		})
+	}
+
+	return t, nil
+}
+
+func encodeParameterValue(parameter *sproto.Payload_Template_Parameter, dataType sproto.DataType, value any) bool {
+	element, ok := encodeDataSetValue(dataType, value)
+	if !ok {
+		return false
+	}
+
+	switch v := element.GetValue().(type) {
+	case *sproto.Payload_DataSet_DataSetValue_IntValue:
+		parameter.Value = &sproto.Payload_Template_Parameter_IntValue{IntValue: v.IntValue}
+	case *sproto.Payload_DataSet_DataSetValue_LongValue:
+		parameter.Value = &sproto.Payload_Template_Parameter_LongValue{LongValue: v.LongValue}
+	case *sproto.Payload_DataSet_DataSetValue_FloatValue:
+		parameter.Value = &sproto.Payload_Template_Parameter_FloatValue{FloatValue: v.FloatValue}
+	case *sproto.Payload_DataSet_DataSetValue_DoubleValue:
+		parameter.Value = &sproto.Payload_Template_Parameter_DoubleValue{DoubleValue: v.DoubleValue}
+	case *sproto.Payload_DataSet_DataSetValue_BooleanValue:
+		parameter.Value = &sproto.Payload_Template_Parameter_BooleanValue{BooleanValue: v.BooleanValue}
+	case *sproto.Payload_DataSet_DataSetValue_StringValue:
+		parameter.Value = &sproto.Payload_Template_Parameter_StringValue{StringValue: v.StringValue}
+	default:
+		return false
+	}
+
+	return true
+}
+
+func decodeParameterValue(dataType sproto.DataType, parameter *sproto.Payload_Template_Parameter) (any, error) {
+	element := &sproto.Payload_DataSet_DataSetValue{}
+	switch v := parameter.GetValue().(type) {
+	case nil:
+		return nil, nil
+	case *sproto.Payload_Template_Parameter_IntValue:
+		element.Value = &sproto.Payload_DataSet_DataSetValue_IntValue{IntValue: v.IntValue}
+	case *sproto.Payload_Template_Parameter_LongValue:
+		element.Value = &sproto.Payload_DataSet_DataSetValue_LongValue{LongValue: v.LongValue}
+	case *sproto.Payload_Template_Parameter_FloatValue:
+		element.Value = &sproto.Payload_DataSet_DataSetValue_FloatValue{FloatValue: v.FloatValue}
+	case *sproto.Payload_Template_Parameter_DoubleValue:
+		element.Value = &sproto.Payload_DataSet_DataSetValue_DoubleValue{DoubleValue: v.DoubleValue}
+	case *sproto.Payload_Template_Parameter_BooleanValue:
+		element.Value = &sproto.Payload_DataSet_DataSetValue_BooleanValue{BooleanValue: v.BooleanValue}
+	case *sproto.Payload_Template_Parameter_StringValue:
+		element.Value = &sproto.Payload_DataSet_DataSetValue_StringValue{StringValue: v.StringValue}
+	default:
+		return nil, fmt.Errorf("unsupported parameter value %T", v)
+	}
+
+	return decodeDataSetValue(dataType, element)
+}
+
+type templateRegistry struct {
+	mu          sync.RWMutex
+	definitions map[string]*Template
+	order       []string
+	rebirth     bool
+}
+
+func newTemplateRegistry() *templateRegistry {
+	return &templateRegistry{
+		definitions: make(map[string]*Template),
+	}
+}
+
+func (r *templateRegistry) declare(name string, definition *Template) error {
+	if name == "" {
+		return fmt.Errorf("template name must not be empty")
+	}
+
+	if definition == nil {
+		return fmt.Errorf("template %s must have a definition", name)
+	}
+
+	definition = definition.clone()
+	definition.IsDefinition = true
+	definition.TemplateRef = ""
+
+	if err := r.validateDefinition(name, definition); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.definitions[name]; ok {
+		return fmt.Errorf("template %s is already declared", name)
+	}
+
+	r.definitions[name] = definition
+	r.order = append(r.order, name)
+	r.rebirth = true
+
+	return nil
+}
+
+func (r *templateRegistry) validateDefinition(name string, definition *Template) error {
+	seen := make(map[string]bool)
+	for _, parameter := range definition.Parameters {
+		if seen[parameter.Name] {
+			return fmt.Errorf("template %s declares parameter %s twice", name, parameter.Name)
+		}
+		seen[parameter.Name] = true
+
+		if !isDataSetDataType(parameter.DataType) {
+			return fmt.Errorf("template %s parameter %s has unsupported datatype %s", name, parameter.Name, parameter.DataType)
+		}
+	}
+
+	seen = make(map[string]bool)
+	for _, member := range definition.Metrics {
+		if seen[member.Name] {
+			return fmt.Errorf("template %s declares metric %s twice", name, member.Name)
+		}
+		seen[member.Name] = true
+
+		if member.DataType != sproto.DataType_Template {
+			continue
+		}
+
+		nested, ok := member.Value.(*Template)
+		if !ok || nested == nil {
+			return fmt.Errorf("template %s metric %s must hold a template instance", name, member.Name)
+		}
+
+		if err := r.validate(nested); err != nil {
+			return fmt.Errorf("template %s metric %s: %w", name, member.Name, err)
+		}
+	}
+
+	if _, err := definition.toProto(); err != nil {
+		return fmt.Errorf("template %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func (r *templateRegistry) instance(name string) (*Template, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	definition, ok := r.definitions[name]
+	if !ok {
+		return nil, fmt.Errorf("template %s is not declared", name)
+	}
+
+	instance := definition.clone()
+	instance.IsDefinition = false
+	instance.TemplateRef = name
+
+	return instance, nil
+}
+
+func (r *templateRegistry) validate(instance *Template) error {
+	if instance.IsDefinition {
+		return fmt.Errorf("template definitions cannot be published as metric values")
+	}
+
+	r.mu.RLock()
+	definition, ok := r.definitions[instance.TemplateRef]
+	r.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("template instance references undeclared template %q", instance.TemplateRef)
+	}
+
+	if instance.Version != "" && instance.Version != definition.Version {
+		return fmt.Errorf("template instance version %s does not match %s version %s", instance.Version, instance.TemplateRef, definition.Version)
+	}
+
+	for _, parameter := range instance.Parameters {
+		var expected *TemplateParameter
+		for i := range definition.Parameters {
+			if definition.Parameters[i].Name == parameter.Name {
+				expected = &definition.Parameters[i]
+				break
+			}
+		}
+
+		if expected == nil {
+			return fmt.Errorf("template %s has no parameter %s", instance.TemplateRef, parameter.Name)
+		}
+
+		if parameter.DataType != expected.DataType {
+			return fmt.Errorf("template %s parameter %s has datatype %s, expected %s", instance.TemplateRef, parameter.Name, parameter.DataType, expected.DataType)
+		}
+	}
+
+	for _, member := range instance.Metrics {
+		expected, ok := definition.Metric(member.Name)
+		if !ok {
+			return fmt.Errorf("template %s has no metric %s", instance.TemplateRef, member.Name)
+		}
+
+		if member.DataType != expected.DataType {
+			return fmt.Errorf("template %s metric %s has datatype %s, expected %s", instance.TemplateRef, member.Name, member.DataType, expected.DataType)
+		}
+
+		if nested, ok := member.Value.(*Template); ok && nested != nil {
+			if err := r.validate(nested); err != nil {
+				return fmt.Errorf("template %s metric %s: %w", instance.TemplateRef, member.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *templateRegistry) needsRebirth() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.rebirth
+}
+
+func (r *templateRegistry) birthMetrics() ([]*sproto.Payload_Metric, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	metrics := make([]*sproto.Payload_Metric, 0, len(r.order))
+	for _, name := range r.order {
+		template, err := r.definitions[name].toProto()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode template %s: %w", name, err)
+		}
+
+		metrics = append(metrics, &sproto.Payload_Metric{
+			Name:      proto.String(name),
+			Timestamp: proto.Uint64(uint64(time.Now().UnixMilli())),
+			Datatype:  proto.Uint32(uint32(sproto.DataType_Template)),
+			Value:     &sproto.Payload_Metric_TemplateValue{TemplateValue: template},
+		})
+	}
+	r.rebirth = false
+
+	return metrics, nil
+}
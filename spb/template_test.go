@@ -0,0 +1,275 @@
+package spb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tjeumaster/go-sparkplug/sproto"
+	"google.golang.org/protobuf/proto"
+)
+
+func motorTemplate() *Template {
+	return &Template{
+		Version: "1.0",
+		Parameters: []TemplateParameter{
+			{Name: "RatedSpeed", DataType: sproto.DataType_Float, Value: float32(1450)},
+			{Name: "Vendor", DataType: sproto.DataType_String},
+		},
+		Metrics: []TemplateMetric{
+			{Name: "Speed", DataType: sproto.DataType_Float},
+			{Name: "Running", DataType: sproto.DataType_Boolean, Value: false},
+			{Name: "Starts", DataType: sproto.DataType_Int16, Value: int16(-1)},
+		},
+	}
+}
+
+func marshalRoundTrip(t *testing.T, payload *sproto.Payload) *sproto.Payload {
+	t.Helper()
+
+	data, err := proto.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded sproto.Payload
+	if err := proto.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	return &decoded
+}
+
+func decodedTemplate(t *testing.T, payload *sproto.Payload, name string) *Template {
+	t.Helper()
+
+	metric, ok := findMetric(payload, name)
+	if !ok {
+		t.Fatalf("payload has no metric %s", name)
+	}
+	if dataType := sproto.DataType(metric.GetDatatype()); dataType != sproto.DataType_Template {
+		t.Fatalf("metric %s datatype = %s, want Template", name, dataType)
+	}
+
+	template, err := DecodeTemplate(metric.GetTemplateValue())
+	if err != nil {
+		t.Fatalf("DecodeTemplate() error = %v", err)
+	}
+
+	return template
+}
+
+func TestTemplateRoundTrip(t *testing.T) {
+	c := NewClient(Config{GroupID: "g", NodeID: "n"})
+	if err := c.DeclareTemplate("Motor", motorTemplate()); err != nil {
+		t.Fatalf("DeclareTemplate() error = %v", err)
+	}
+
+	birth, err := c.buildNBIRTHPayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := motorTemplate()
+	want.IsDefinition = true
+	if got := decodedTemplate(t, marshalRoundTrip(t, birth), "Motor"); !reflect.DeepEqual(got, want) {
+		t.Errorf("NBIRTH definition = %+v, want %+v", got, want)
+	}
+
+	motor, err := c.NewTemplateInstance("Motor")
+	if err != nil {
+		t.Fatalf("NewTemplateInstance() error = %v", err)
+	}
+	if err := motor.SetMetric("Speed", float32(1420)); err != nil {
+		t.Fatal(err)
+	}
+
+	device := &testDevice{id: "drive", values: map[string]any{"Motor 1": motor}}
+	if err := c.AddDevice(device); err != nil {
+		t.Fatal(err)
+	}
+
+	dbirth, err := c.buildDBIRTHPayload(device)
+	if err != nil {
+		t.Fatalf("buildDBIRTHPayload() error = %v", err)
+	}
+
+	instance := decodedTemplate(t, marshalRoundTrip(t, dbirth), "Motor 1")
+	if instance.IsDefinition || instance.TemplateRef != "Motor" {
+		t.Errorf("DBIRTH instance definition %t ref %q, want an instance of Motor", instance.IsDefinition, instance.TemplateRef)
+	}
+	if speed, _ := instance.Metric("Speed"); speed.Value != float32(1420) {
+		t.Errorf("DBIRTH Speed = %#v, want 1420", speed.Value)
+	}
+
+	if err := motor.SetMetric("Running", true); err != nil {
+		t.Fatal(err)
+	}
+
+	ddata, err := c.buildDDATAPayload("drive", orderedValues(map[string]any{"Motor 1": motor}))
+	if err != nil {
+		t.Fatalf("buildDDATAPayload() error = %v", err)
+	}
+
+	decoded := marshalRoundTrip(t, ddata)
+	if len(decoded.Metrics) != 1 || decoded.Metrics[0].Alias == nil {
+		t.Fatalf("DDATA metrics = %v, want one aliased template", decoded.Metrics)
+	}
+	decoded.Metrics[0].Name = proto.String("Motor 1")
+
+	instance = decodedTemplate(t, decoded, "Motor 1")
+	if running, _ := instance.Metric("Running"); running.Value != true {
+		t.Errorf("DDATA Running = %#v, want true", running.Value)
+	}
+	if starts, _ := instance.Metric("Starts"); starts.Value != int16(-1) {
+		t.Errorf("DDATA Starts = %#v, want -1", starts.Value)
+	}
+}
+
+func TestDeclareTemplateErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		template   string
+		definition *Template
+		want       string
+	}{
+		{
+			name:       "nil definition",
+			template:   "Motor",
+			definition: nil,
+			want:       "must have a definition",
+		},
+		{
+			name:       "empty name",
+			definition: motorTemplate(),
+			want:       "must not be empty",
+		},
+		{
+			name:     "duplicate parameter",
+			template: "Motor",
+			definition: &Template{Parameters: []TemplateParameter{
+				{Name: "Vendor", DataType: sproto.DataType_String},
+				{Name: "Vendor", DataType: sproto.DataType_String},
+			}},
+			want: "parameter Vendor twice",
+		},
+		{
+			name:     "duplicate metric",
+			template: "Motor",
+			definition: &Template{Metrics: []TemplateMetric{
+				{Name: "Speed", DataType: sproto.DataType_Float},
+				{Name: "Speed", DataType: sproto.DataType_Double},
+			}},
+			want: "metric Speed twice",
+		},
+		{
+			name:     "unsupported parameter datatype",
+			template: "Motor",
+			definition: &Template{Parameters: []TemplateParameter{
+				{Name: "Image", DataType: sproto.DataType_Bytes},
+			}},
+			want: "unsupported datatype",
+		},
+		{
+			name:     "nested template without instance",
+			template: "Line",
+			definition: &Template{Metrics: []TemplateMetric{
+				{Name: "Motor", DataType: sproto.DataType_Template},
+			}},
+			want: "must hold a template instance",
+		},
+		{
+			name:     "member value does not match datatype",
+			template: "Motor",
+			definition: &Template{Metrics: []TemplateMetric{
+				{Name: "Speed", DataType: sproto.DataType_Float, Value: "fast"},
+			}},
+			want: "Speed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newTemplateRegistry().declare(tt.template, tt.definition)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("declare() error = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplateInstanceValidation(t *testing.T) {
+	r := newTemplateRegistry()
+	if err := r.declare("Motor", motorTemplate()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.declare("Motor", motorTemplate()); err == nil {
+		t.Error("declare() of a duplicate template error = nil, want an error")
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(*Template)
+		wantErr bool
+	}{
+		{name: "valid instance", modify: func(*Template) {}},
+		{name: "undeclared template", modify: func(m *Template) { m.TemplateRef = "Pump" }, wantErr: true},
+		{name: "definition as value", modify: func(m *Template) { m.IsDefinition = true }, wantErr: true},
+		{name: "version mismatch", modify: func(m *Template) { m.Version = "2.0" }, wantErr: true},
+		{name: "unknown metric", modify: func(m *Template) {
+			m.Metrics = append(m.Metrics, TemplateMetric{Name: "Torque", DataType: sproto.DataType_Float})
+		}, wantErr: true},
+		{name: "metric datatype mismatch", modify: func(m *Template) { m.Metrics[0].DataType = sproto.DataType_Double }, wantErr: true},
+		{name: "unknown parameter", modify: func(m *Template) {
+			m.Parameters = append(m.Parameters, TemplateParameter{Name: "Serial", DataType: sproto.DataType_String})
+		}, wantErr: true},
+		{name: "parameter datatype mismatch", modify: func(m *Template) { m.Parameters[0].DataType = sproto.DataType_Double }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance, err := r.instance("Motor")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.modify(instance)
+
+			if err := r.validate(instance); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTemplateDeclaredAfterBirth(t *testing.T) {
+	loop := NewLoopback()
+	_, events := newLoopbackHost(t, loop)
+	c := newTestClient(t, loop, Config{})
+	if _, err := c.NodeMetrics().Declare(MetricDefinition{Name: "Motor 1", DataType: sproto.DataType_Template}); err != nil {
+		t.Fatal(err)
+	}
+	connectTestClient(t, c)
+	if _, ok := findMetric(waitForEvent(t, events, MessageTypeNBIRTH).payload, "Motor"); ok {
+		t.Fatal("NBIRTH carries the Motor definition before it is declared")
+	}
+
+	if err := c.DeclareTemplate("Motor", motorTemplate()); err != nil {
+		t.Fatal(err)
+	}
+	motor, err := c.NewTemplateInstance("Motor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PublishNDATA(map[string]any{"Motor 1": motor}); err != nil {
+		t.Fatalf("PublishNDATA() error = %v", err)
+	}
+
+	birth := waitForEvent(t, events, MessageTypeNBIRTH)
+	if definition := decodedTemplate(t, birth.payload, "Motor"); !definition.IsDefinition {
+		t.Errorf("NBIRTH Motor = %+v, want a definition", definition)
+	}
+	data := waitForEvent(t, events, MessageTypeNDATA)
+	if _, ok := findMetric(data.payload, "Motor 1"); !ok {
+		t.Errorf("NDATA = %v, want Motor 1", data.payload.Metrics)
+	}
+}
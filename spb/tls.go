@@ -0,0 +1,128 @@
+package spb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+)
+
+type TLSConfig struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	MinVersion         uint16
+	InsecureSkipVerify bool
+	ReloadCerts        bool
+}
+
+func (t *TLSConfig) build() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         t.ServerName,
+		MinVersion:         t.MinVersion,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle %s: %w", t.CAFile, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", t.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		if t.CertFile == "" || t.KeyFile == "" {
+			return nil, fmt.Errorf("client certificate requires both CertFile and KeyFile")
+		}
+
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate %s: %w", t.CertFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+func (t *TLSConfig) buildReloading(host string) (*tls.Config, error) {
+	config, err := t.build()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(config.Certificates) > 0 {
+		config.Certificates = nil
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to reload client certificate %s: %w", t.CertFile, err)
+			}
+			return &cert, nil
+		}
+	}
+
+	if config.RootCAs != nil && !t.InsecureSkipVerify {
+		serverName := t.ServerName
+		if serverName == "" {
+			serverName = host
+		}
+		if serverName == "" {
+			return nil, fmt.Errorf("TLS certificate reloading requires a ServerName or broker host to verify")
+		}
+
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return t.verifyConnection(state, serverName)
+		}
+	}
+
+	return config, nil
+}
+
+func (t *TLSConfig) verifyConnection(state tls.ConnectionState, serverName string) error {
+	config, err := t.build()
+	if err != nil {
+		return err
+	}
+
+	if len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("server presented no certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err = state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         config.RootCAs,
+		DNSName:       serverName,
+		Intermediates: intermediates,
+	})
+
+	return err
+}
+
+func (t *TLSConfig) onConnectAttempt(broker *url.URL, current *tls.Config) *tls.Config {
+	config, err := t.build()
+	if err != nil {
+		log.Printf("Failed to reload TLS configuration for %s, using previous one: %v", broker, err)
+		return current
+	}
+
+	return config
+}
@@ -0,0 +1,383 @@
+package spb
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) string {
+	t.Helper()
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func serveTLS(t *testing.T, ca *testCA, clientCA *testCA) (string, <-chan string) {
+	t.Helper()
+
+	certPEM, keyPEM := ca.issue(t, "localhost", x509.ExtKeyUsageServerAuth)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if clientCA != nil {
+		pool := x509.NewCertPool()
+		pool.AddCert(clientCA.cert)
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	clients := make(chan string, 8)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			tlsConn := conn.(*tls.Conn)
+			if err := tlsConn.Handshake(); err == nil {
+				if peers := tlsConn.ConnectionState().PeerCertificates; len(peers) > 0 {
+					clients <- peers[0].SerialNumber.String()
+				} else {
+					clients <- ""
+				}
+			}
+			conn.Close()
+		}
+	}()
+
+	return listener.Addr().String(), clients
+}
+
+func dialTLS(address string, config *tls.Config) error {
+	config.ServerName = "localhost"
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: loopbackTimeout}, "tcp", address, config)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+func TestBrokerURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		broker  Broker
+		want    string
+		wantErr bool
+	}{
+		{name: "tcp", broker: Broker{Host: "localhost", Port: 1883}, want: "tcp://localhost:1883"},
+		{name: "tls default scheme", broker: Broker{Host: "localhost", Port: 8883, TLS: &TLSConfig{}}, want: "ssl://localhost:8883"},
+		{name: "ipv6", broker: Broker{Host: "::1", Port: 1883}, want: "tcp://[::1]:1883"},
+		{name: "websocket path", broker: Broker{Scheme: "wss", Host: "fe80::1", Port: 443, Path: "/mqtt", TLS: &TLSConfig{}}, want: "wss://[fe80::1]:443/mqtt"},
+		{name: "path ignored for tcp", broker: Broker{Host: "broker", Port: 1883, Path: "/mqtt"}, want: "tcp://broker:1883"},
+		{name: "tls over tcp", broker: Broker{Scheme: "tcp", Host: "broker", Port: 1883, TLS: &TLSConfig{}}, wantErr: true},
+		{name: "unknown scheme", broker: Broker{Scheme: "quic", Host: "broker", Port: 1883}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.broker.url()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("url() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("url() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTLSConfigBuild(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "ca")
+	certPEM, keyPEM := ca.issue(t, "edge", x509.ExtKeyUsageClientAuth)
+
+	caFile := writeTestFile(t, filepath.Join(dir, "ca.pem"), ca.pem)
+	certFile := writeTestFile(t, filepath.Join(dir, "edge.pem"), certPEM)
+	keyFile := writeTestFile(t, filepath.Join(dir, "edge.key"), keyPEM)
+	garbage := writeTestFile(t, filepath.Join(dir, "garbage.pem"), []byte("not a certificate"))
+
+	tests := []struct {
+		name   string
+		config TLSConfig
+		want   string
+		check  func(t *testing.T, config *tls.Config)
+	}{
+		{
+			name:   "defaults",
+			config: TLSConfig{ServerName: "broker"},
+			check: func(t *testing.T, config *tls.Config) {
+				if config.MinVersion != tls.VersionTLS12 || config.ServerName != "broker" || config.RootCAs != nil {
+					t.Errorf("config = min %#x server %q roots %v, want TLS 1.2, broker and system roots", config.MinVersion, config.ServerName, config.RootCAs)
+				}
+			},
+		},
+		{
+			name:   "CA and client certificate",
+			config: TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, MinVersion: tls.VersionTLS13},
+			check: func(t *testing.T, config *tls.Config) {
+				if config.RootCAs == nil || len(config.Certificates) != 1 || config.MinVersion != tls.VersionTLS13 {
+					t.Errorf("config = roots %v, %d certificates, min %#x, want the CA, one certificate and TLS 1.3", config.RootCAs, len(config.Certificates), config.MinVersion)
+				}
+			},
+		},
+		{name: "missing CA file", config: TLSConfig{CAFile: filepath.Join(dir, "missing.pem")}, want: "failed to read CA bundle"},
+		{name: "CA file without certificates", config: TLSConfig{CAFile: garbage}, want: "no certificates found"},
+		{name: "certificate without key", config: TLSConfig{CertFile: certFile}, want: "requires both CertFile and KeyFile"},
+		{name: "key without certificate", config: TLSConfig{KeyFile: keyFile}, want: "requires both CertFile and KeyFile"},
+		{name: "mismatched key pair", config: TLSConfig{CertFile: certFile, KeyFile: garbage}, want: "failed to load client certificate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := tt.config.build()
+			if tt.want != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Fatalf("build() error = %v, want an error containing %q", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("build() error = %v", err)
+			}
+			tt.check(t, config)
+		})
+	}
+}
+
+func TestTLSVerifyConnection(t *testing.T) {
+	dir := t.TempDir()
+	trusted := newTestCA(t, "trusted")
+	untrusted := newTestCA(t, "untrusted")
+	address, _ := serveTLS(t, trusted, nil)
+
+	caFile := writeTestFile(t, filepath.Join(dir, "ca.pem"), untrusted.pem)
+	tlsConfig := &TLSConfig{CAFile: caFile, ReloadCerts: true}
+
+	config, err := tlsConfig.buildReloading("localhost")
+	if err != nil {
+		t.Fatalf("buildReloading() error = %v", err)
+	}
+	if !config.InsecureSkipVerify || config.VerifyConnection == nil {
+		t.Fatal("buildReloading() did not install VerifyConnection")
+	}
+
+	if err := dialTLS(address, config.Clone()); err == nil {
+		t.Fatal("handshake with an untrusted CA succeeded, want it rejected")
+	}
+
+	writeTestFile(t, caFile, trusted.pem)
+	if err := dialTLS(address, config.Clone()); err != nil {
+		t.Fatalf("handshake after reloading the trusted CA error = %v", err)
+	}
+
+	writeTestFile(t, caFile, untrusted.pem)
+	if err := dialTLS(address, config.Clone()); err == nil {
+		t.Error("handshake after rotating back to an untrusted CA succeeded, want it rejected")
+	}
+}
+
+func TestTLSVerifyConnectionServerName(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	address, _ := serveTLS(t, ca, nil)
+	caFile := writeTestFile(t, filepath.Join(t.TempDir(), "ca.pem"), ca.pem)
+
+	tests := []struct {
+		name       string
+		host       string
+		serverName string
+		wantErr    bool
+	}{
+		{name: "matching host", host: "localhost"},
+		{name: "matching server name", host: "127.0.0.1", serverName: "localhost"},
+		{name: "IP host without IP SAN", host: "127.0.0.1", wantErr: true},
+		{name: "mismatched server name", host: "localhost", serverName: "broker.example.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig := &TLSConfig{CAFile: caFile, ServerName: tt.serverName, ReloadCerts: true}
+			config, err := tlsConfig.buildReloading(tt.host)
+			if err != nil {
+				t.Fatalf("buildReloading() error = %v", err)
+			}
+
+			conn, err := tls.DialWithDialer(&net.Dialer{Timeout: loopbackTimeout}, "tcp", address, config)
+			if err == nil {
+				conn.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("handshake error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTLSReloadsClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "ca")
+	address, clients := serveTLS(t, ca, ca)
+
+	caFile := writeTestFile(t, filepath.Join(dir, "ca.pem"), ca.pem)
+	certPEM, keyPEM := ca.issue(t, "edge", x509.ExtKeyUsageClientAuth)
+	certFile := writeTestFile(t, filepath.Join(dir, "edge.pem"), certPEM)
+	keyFile := writeTestFile(t, filepath.Join(dir, "edge.key"), keyPEM)
+
+	tlsConfig := &TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ReloadCerts: true}
+	config, err := tlsConfig.buildReloading("localhost")
+	if err != nil {
+		t.Fatalf("buildReloading() error = %v", err)
+	}
+
+	serial := func() string {
+		t.Helper()
+
+		if err := dialTLS(address, config.Clone()); err != nil {
+			t.Fatalf("handshake error = %v", err)
+		}
+		select {
+		case serial := <-clients:
+			return serial
+		case <-time.After(loopbackTimeout):
+			t.Fatal("timed out waiting for the server handshake")
+			return ""
+		}
+	}
+
+	first := serial()
+
+	certPEM, keyPEM = ca.issue(t, "edge", x509.ExtKeyUsageClientAuth)
+	writeTestFile(t, certFile, certPEM)
+	writeTestFile(t, keyFile, keyPEM)
+
+	if second := serial(); second == first {
+		t.Errorf("client certificate serial after rotation = %s, want a new certificate", second)
+	}
+}
+
+func TestTLSConnectAttemptReload(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestCA(t, "first")
+	second := newTestCA(t, "second")
+
+	caFile := writeTestFile(t, filepath.Join(dir, "ca.pem"), first.pem)
+	tlsConfig := &TLSConfig{CAFile: caFile, ReloadCerts: true}
+	broker := &url.URL{Scheme: "ssl", Host: "localhost:8883"}
+
+	current, err := tlsConfig.build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, caFile, second.pem)
+	reloaded := tlsConfig.onConnectAttempt(broker, current)
+	if reloaded == current || reloaded.RootCAs.Equal(current.RootCAs) {
+		t.Error("onConnectAttempt() kept the old CA bundle, want the rotated one")
+	}
+
+	writeTestFile(t, caFile, []byte("truncated"))
+	if got := tlsConfig.onConnectAttempt(broker, reloaded); got != reloaded {
+		t.Error("onConnectAttempt() with a broken CA bundle did not keep the previous configuration")
+	}
+}
@@ -0,0 +1,91 @@
+package spb
+
+import (
+	"fmt"
+	"strings"
+)
+
+const Namespace = "spBv1.0"
+
+const (
+	MessageTypeNBIRTH = "NBIRTH"
+	MessageTypeNDEATH = "NDEATH"
+	MessageTypeDBIRTH = "DBIRTH"
+	MessageTypeDDEATH = "DDEATH"
+	MessageTypeNDATA  = "NDATA"
+	MessageTypeDDATA  = "DDATA"
+	MessageTypeNCMD   = "NCMD"
+	MessageTypeDCMD   = "DCMD"
+	MessageTypeSTATE  = "STATE"
+)
+
+type Topic struct {
+	GroupID     string
+	MessageType string
+	NodeID      string
+	DeviceID    string
+	HostID      string
+}
+
+func ParseTopic(topic string) (Topic, error) {
+	parts := strings.Split(topic, "/")
+	if len(parts) == 3 && parts[0] == Namespace && parts[1] == MessageTypeSTATE {
+		return Topic{MessageType: MessageTypeSTATE, HostID: parts[2]}, nil
+	}
+
+	if len(parts) < 4 || len(parts) > 5 {
+		return Topic{}, fmt.Errorf("invalid Sparkplug topic %s", topic)
+	}
+
+	if parts[0] != Namespace {
+		return Topic{}, fmt.Errorf("invalid Sparkplug namespace %s in topic %s", parts[0], topic)
+	}
+
+	t := Topic{
+		GroupID:     parts[1],
+		MessageType: parts[2],
+		NodeID:      parts[3],
+	}
+
+	switch t.MessageType {
+	case MessageTypeNBIRTH, MessageTypeNDEATH, MessageTypeNDATA, MessageTypeNCMD:
+		if len(parts) != 4 {
+			return Topic{}, fmt.Errorf("unexpected device ID in %s topic %s", t.MessageType, topic)
+		}
+
+	case MessageTypeDBIRTH, MessageTypeDDEATH, MessageTypeDDATA, MessageTypeDCMD:
+		if len(parts) != 5 {
+			return Topic{}, fmt.Errorf("missing device ID in %s topic %s", t.MessageType, topic)
+		}
+		t.DeviceID = parts[4]
+
+	default:
+		return Topic{}, fmt.Errorf("unknown message type %s in topic %s", t.MessageType, topic)
+	}
+
+	return t, nil
+}
+
+func (t Topic) String() string {
+	if t.MessageType == MessageTypeSTATE {
+		return stateTopic(t.HostID)
+	}
+
+	if t.DeviceID != "" {
+		return deviceTopic(t.GroupID, t.MessageType, t.NodeID, t.DeviceID)
+	}
+
+	return nodeTopic(t.GroupID, t.MessageType, t.NodeID)
+}
+
+func nodeTopic(groupID, messageType, nodeID string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", Namespace, groupID, messageType, nodeID)
+}
+
+func deviceTopic(groupID, messageType, nodeID, deviceID string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s", Namespace, groupID, messageType, nodeID, deviceID)
+}
+
+func stateTopic(hostID string) string {
+	return fmt.Sprintf("%s/%s/%s", Namespace, MessageTypeSTATE, hostID)
+}
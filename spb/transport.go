@@ -0,0 +1,192 @@
+package spb
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type Protocol int
+
+const (
+	ProtocolMQTT311 Protocol = iota
+	ProtocolMQTT5
+)
+
+type MQTT5Options struct {
+	SessionExpiry  time.Duration
+	MessageExpiry  time.Duration
+	UserProperties map[string]string
+}
+
+type ReasonCodeError struct {
+	Packet string
+	Code   byte
+	Reason string
+}
+
+func (e *ReasonCodeError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("%s returned reason code 0x%02X", e.Packet, e.Code)
+	}
+
+	return fmt.Sprintf("%s returned reason code 0x%02X: %s", e.Packet, e.Code, e.Reason)
+}
+
+type WillMessage struct {
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retained bool
+}
+
+type TransportMessage struct {
+	Topic          string
+	Payload        []byte
+	UserProperties map[string]string
+}
+
+type PublishOptions struct {
+	MessageExpiry  time.Duration
+	UserProperties map[string]string
+}
+
+type TransportConfig struct {
+	Broker           Broker
+	ClientID         string
+	AutoReconnect    bool
+	SessionExpiry    time.Duration
+	Will             func() (*WillMessage, error)
+	OnConnect        func()
+	OnConnectionLost func(err error)
+}
+
+type Transport interface {
+	Connect() error
+	Disconnect()
+	IsConnected() bool
+	Publish(topic string, qos byte, retained bool, payload []byte, options PublishOptions) error
+	Subscribe(topic string, qos byte, handler func(msg TransportMessage)) error
+}
+
+type TransportFactory func(config TransportConfig) (Transport, error)
+
+func newTransport(protocol Protocol, config TransportConfig) (Transport, error) {
+	switch protocol {
+	case ProtocolMQTT311:
+		return newPahoTransport(config)
+	case ProtocolMQTT5:
+		return newMQTT5Transport(config)
+	default:
+		return nil, fmt.Errorf("unsupported MQTT protocol %d", protocol)
+	}
+}
+
+type pahoTransport struct {
+	config TransportConfig
+	client mqtt.Client
+}
+
+func newPahoTransport(config TransportConfig) (*pahoTransport, error) {
+	mqttBroker, err := config.Broker.url()
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker configuration: %w", err)
+	}
+
+	t := &pahoTransport{config: config}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(mqttBroker).
+		SetClientID(config.ClientID).
+		SetAutoReconnect(config.AutoReconnect).
+		SetConnectRetry(config.AutoReconnect).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			if config.OnConnect != nil {
+				config.OnConnect()
+			}
+		}).
+		SetReconnectingHandler(func(client mqtt.Client, opts *mqtt.ClientOptions) {
+			if err := t.setWill(opts); err != nil {
+				log.Printf("Failed to update will before reconnect: %v", err)
+			}
+		}).
+		SetConnectionLostHandler(func(client mqtt.Client, err error) {
+			if config.OnConnectionLost != nil {
+				config.OnConnectionLost(err)
+			}
+		})
+
+	if err := configureConnection(opts, config.Broker.Username, config.Broker.Password, config.Broker.TLS); err != nil {
+		return nil, fmt.Errorf("failed to configure broker connection: %w", err)
+	}
+
+	if err := t.setWill(opts); err != nil {
+		return nil, err
+	}
+
+	t.client = mqtt.NewClient(opts)
+
+	return t, nil
+}
+
+func (t *pahoTransport) setWill(opts *mqtt.ClientOptions) error {
+	if t.config.Will == nil {
+		return nil
+	}
+
+	will, err := t.config.Will()
+	if err != nil {
+		return err
+	}
+
+	if will != nil {
+		opts.SetBinaryWill(will.Topic, will.Payload, will.QoS, will.Retained)
+	}
+
+	return nil
+}
+
+func (t *pahoTransport) Connect() error {
+	token := t.client.Connect()
+	token.Wait()
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("failed to connect to MQTT broker: %w", err)
+	}
+
+	return nil
+}
+
+func (t *pahoTransport) Disconnect() {
+	t.client.Disconnect(250)
+}
+
+func (t *pahoTransport) IsConnected() bool {
+	return t.client.IsConnected()
+}
+
+func (t *pahoTransport) Publish(topic string, qos byte, retained bool, payload []byte, options PublishOptions) error {
+	token := t.client.Publish(topic, qos, retained, payload)
+	token.Wait()
+
+	return token.Error()
+}
+
+func (t *pahoTransport) Subscribe(topic string, qos byte, handler func(msg TransportMessage)) error {
+	token := t.client.Subscribe(topic, qos, func(client mqtt.Client, msg mqtt.Message) {
+		handler(TransportMessage{Topic: msg.Topic(), Payload: msg.Payload()})
+	})
+	token.Wait()
+	if err := token.Error(); err != nil {
+		return err
+	}
+
+	if subscribeToken, ok := token.(*mqtt.SubscribeToken); ok {
+		if code, ok := subscribeToken.Result()[topic]; ok && code >= 0x80 {
+			return &ReasonCodeError{Packet: "SUBACK", Code: code}
+		}
+	}
+
+	return nil
+}
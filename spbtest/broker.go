@@ -0,0 +1,618 @@
+package spbtest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetPubrec      = 5
+	packetPubrel      = 6
+	packetPubcomp     = 7
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetUnsubscribe = 10
+	packetUnsuback    = 11
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+)
+
+type Broker struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	conns    map[*session]bool
+	sessions map[string]*session
+	retained map[string]message
+	closed   bool
+	nextID   int
+	wg       sync.WaitGroup
+}
+
+type message struct {
+	topic    string
+	payload  []byte
+	qos      byte
+	retained bool
+}
+
+func NewBroker() (*Broker, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start MQTT broker: %w", err)
+	}
+
+	b := &Broker{
+		listener: listener,
+		conns:    make(map[*session]bool),
+		sessions: make(map[string]*session),
+		retained: make(map[string]message),
+	}
+
+	b.wg.Add(1)
+	go b.serve()
+
+	return b, nil
+}
+
+func (b *Broker) Host() string {
+	return "127.0.0.1"
+}
+
+func (b *Broker) Port() int {
+	return b.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (b *Broker) Addr() string {
+	return b.listener.Addr().String()
+}
+
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	sessions := make([]*session, 0, len(b.conns))
+	for s := range b.conns {
+		sessions = append(sessions, s)
+	}
+	b.mu.Unlock()
+
+	err := b.listener.Close()
+	for _, s := range sessions {
+		s.close()
+	}
+	b.wg.Wait()
+
+	return err
+}
+
+func (b *Broker) Publish(topic string, payload []byte, retained bool) {
+	b.route(message{topic: topic, payload: payload, retained: retained})
+}
+
+func (b *Broker) Retained(topic string) ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	msg, ok := b.retained[topic]
+	return msg.payload, ok
+}
+
+func (b *Broker) Connected(clientID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, ok := b.sessions[clientID]
+	return ok
+}
+
+func (b *Broker) DropClient(clientID string) bool {
+	b.mu.Lock()
+	s, ok := b.sessions[clientID]
+	b.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	s.close()
+	<-s.done
+
+	return true
+}
+
+func (b *Broker) serve() {
+	defer b.wg.Done()
+
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.handle(conn)
+		}()
+	}
+}
+
+func (b *Broker) handle(conn net.Conn) {
+	s := &session{
+		broker:        b,
+		conn:          conn,
+		reader:        bufio.NewReader(conn),
+		subscriptions: make(map[string]byte),
+		done:          make(chan struct{}),
+	}
+	defer close(s.done)
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		conn.Close()
+		return
+	}
+	b.conns[s] = true
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, s)
+		b.mu.Unlock()
+	}()
+
+	if err := s.connect(); err != nil {
+		log.Printf("Rejected MQTT connection from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	err := s.serve()
+	s.close()
+
+	b.mu.Lock()
+	if b.sessions[s.clientID] == s {
+		delete(b.sessions, s.clientID)
+	}
+	closed := b.closed
+	b.mu.Unlock()
+
+	s.mu.Lock()
+	will := s.will
+	s.mu.Unlock()
+
+	if will != nil && !closed {
+		log.Printf("Publishing will of MQTT client %s: %v", s.clientID, err)
+		b.route(*will)
+	}
+}
+
+func (b *Broker) register(s *session) bool {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return false
+	}
+
+	if s.clientID == "" {
+		b.nextID++
+		s.clientID = fmt.Sprintf("spbtest-%d", b.nextID)
+	}
+
+	previous := b.sessions[s.clientID]
+	b.sessions[s.clientID] = s
+	b.mu.Unlock()
+
+	if previous != nil {
+		previous.close()
+		<-previous.done
+	}
+
+	return true
+}
+
+func (b *Broker) route(msg message) {
+	b.mu.Lock()
+	if msg.retained {
+		if len(msg.payload) == 0 {
+			delete(b.retained, msg.topic)
+		} else {
+			b.retained[msg.topic] = msg
+		}
+	}
+
+	sessions := make([]*session, 0, len(b.sessions))
+	for _, s := range b.sessions {
+		sessions = append(sessions, s)
+	}
+	b.mu.Unlock()
+
+	msg.retained = false
+	for _, s := range sessions {
+		if qos, ok := s.matches(msg.topic); ok {
+			s.deliver(msg, min(qos, msg.qos))
+		}
+	}
+}
+
+func (b *Broker) retainedMatching(filter string) []message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var messages []message
+	for topic, msg := range b.retained {
+		if topicMatches(filter, topic) {
+			messages = append(messages, msg)
+		}
+	}
+
+	return messages
+}
+
+type session struct {
+	broker    *Broker
+	conn      net.Conn
+	reader    *bufio.Reader
+	clientID  string
+	keepAlive time.Duration
+	done      chan struct{}
+
+	writeMu sync.Mutex
+
+	mu            sync.Mutex
+	will          *message
+	subscriptions map[string]byte
+	packetID      uint16
+	closed        bool
+}
+
+func (s *session) connect() error {
+	s.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	packetType, _, body, err := s.readPacket()
+	if err != nil {
+		return err
+	}
+	if packetType != packetConnect {
+		return fmt.Errorf("expected CONNECT, got packet type %d", packetType)
+	}
+
+	r := &packetReader{data: body}
+	protocol := r.string()
+	level := r.byte()
+	flags := r.byte()
+	keepAlive := r.uint16()
+	s.clientID = r.string()
+
+	if flags&0x04 != 0 {
+		s.will = &message{
+			topic:    r.string(),
+			payload:  r.bytes(),
+			qos:      min((flags>>3)&0x03, 1),
+			retained: flags&0x20 != 0,
+		}
+	}
+	if flags&0x80 != 0 {
+		r.string()
+	}
+	if flags&0x40 != 0 {
+		r.bytes()
+	}
+	if r.err != nil {
+		return fmt.Errorf("malformed CONNECT: %w", r.err)
+	}
+
+	if protocol != "MQTT" || level != 4 {
+		s.writePacket(packetConnack<<4, []byte{0, 0x01})
+		return fmt.Errorf("unsupported protocol %s level %d", protocol, level)
+	}
+
+	s.keepAlive = time.Duration(keepAlive) * time.Second
+	if !s.broker.register(s) {
+		return errors.New("broker is closed")
+	}
+
+	return s.writePacket(packetConnack<<4, []byte{0, 0})
+}
+
+func (s *session) serve() error {
+	for {
+		if s.keepAlive > 0 {
+			s.conn.SetReadDeadline(time.Now().Add(s.keepAlive * 3 / 2))
+		} else {
+			s.conn.SetReadDeadline(time.Time{})
+		}
+
+		packetType, flags, body, err := s.readPacket()
+		if err != nil {
+			return err
+		}
+
+		r := &packetReader{data: body}
+		switch packetType {
+		case packetPublish:
+			qos := (flags >> 1) & 0x03
+			msg := message{topic: r.string(), qos: min(qos, 1), retained: flags&0x01 != 0}
+			var packetID uint16
+			if qos > 0 {
+				packetID = r.uint16()
+			}
+			msg.payload = r.rest()
+			if r.err != nil {
+				return fmt.Errorf("malformed PUBLISH: %w", r.err)
+			}
+
+			switch qos {
+			case 1:
+				s.writePacket(packetPuback<<4, packetIDBytes(packetID))
+			case 2:
+				s.writePacket(packetPubrec<<4, packetIDBytes(packetID))
+			}
+			s.broker.route(msg)
+
+		case packetPubrel:
+			s.writePacket(packetPubcomp<<4, body)
+
+		case packetSubscribe:
+			packetID := r.uint16()
+			var granted []byte
+			var filters []string
+			for r.remaining() > 0 {
+				filter := r.string()
+				qos := min(r.byte()&0x03, 1)
+				filters = append(filters, filter)
+				granted = append(granted, qos)
+
+				s.mu.Lock()
+				s.subscriptions[filter] = qos
+				s.mu.Unlock()
+			}
+			if r.err != nil {
+				return fmt.Errorf("malformed SUBSCRIBE: %w", r.err)
+			}
+
+			s.writePacket(packetSuback<<4, append(packetIDBytes(packetID), granted...))
+			for i, filter := range filters {
+				for _, msg := range s.broker.retainedMatching(filter) {
+					s.deliver(msg, min(granted[i], msg.qos))
+				}
+			}
+
+		case packetUnsubscribe:
+			packetID := r.uint16()
+			for r.remaining() > 0 {
+				filter := r.string()
+				s.mu.Lock()
+				delete(s.subscriptions, filter)
+				s.mu.Unlock()
+			}
+			if r.err != nil {
+				return fmt.Errorf("malformed UNSUBSCRIBE: %w", r.err)
+			}
+
+			s.writePacket(packetUnsuback<<4, packetIDBytes(packetID))
+
+		case packetPingreq:
+			s.writePacket(packetPingresp<<4, nil)
+
+		case packetDisconnect:
+			s.mu.Lock()
+			s.will = nil
+			s.mu.Unlock()
+			return nil
+
+		case packetPuback, packetPubrec, packetPubcomp:
+
+		default:
+			return fmt.Errorf("unexpected packet type %d", packetType)
+		}
+	}
+}
+
+func (s *session) matches(topic string) (byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var qos byte
+	matched := false
+	for filter, granted := range s.subscriptions {
+		if topicMatches(filter, topic) {
+			matched = true
+			qos = max(qos, granted)
+		}
+	}
+
+	return qos, matched
+}
+
+func (s *session) deliver(msg message, qos byte) {
+	flags := byte(packetPublish<<4) | qos<<1
+	if msg.retained {
+		flags |= 0x01
+	}
+
+	body := stringBytes(msg.topic)
+	if qos > 0 {
+		s.mu.Lock()
+		s.packetID++
+		if s.packetID == 0 {
+			s.packetID = 1
+		}
+		packetID := s.packetID
+		s.mu.Unlock()
+		body = append(body, packetIDBytes(packetID)...)
+	}
+	body = append(body, msg.payload...)
+
+	if err := s.writePacket(flags, body); err != nil {
+		s.close()
+	}
+}
+
+func (s *session) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		s.conn.Close()
+	}
+}
+
+func (s *session) readPacket() (byte, byte, []byte, error) {
+	header, err := s.reader.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, 0, nil, errors.New("malformed remaining length")
+		}
+
+		digit, err := s.reader.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+
+		length += int(digit&0x7f) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.reader, body); err != nil {
+		return 0, 0, nil, err
+	}
+
+	return header >> 4, header & 0x0f, body, nil
+}
+
+func (s *session) writePacket(header byte, body []byte) error {
+	packet := []byte{header}
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_, err := s.conn.Write(packet)
+	return err
+}
+
+type packetReader struct {
+	data []byte
+	err  error
+}
+
+func (r *packetReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if len(r.data) < n {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *packetReader) byte() byte {
+	b := r.take(1)
+	if b == nil {
+		return 0
+	}
+
+	return b[0]
+}
+
+func (r *packetReader) uint16() uint16 {
+	b := r.take(2)
+	if b == nil {
+		return 0
+	}
+
+	return uint16(b[0])<<8 | uint16(b[1])
+}
+
+func (r *packetReader) bytes() []byte {
+	return r.take(int(r.uint16()))
+}
+
+func (r *packetReader) string() string {
+	return string(r.bytes())
+}
+
+func (r *packetReader) rest() []byte {
+	return r.take(len(r.data))
+}
+
+func (r *packetReader) remaining() int {
+	if r.err != nil {
+		return 0
+	}
+
+	return len(r.data)
+}
+
+func packetIDBytes(packetID uint16) []byte {
+	return []byte{byte(packetID >> 8), byte(packetID)}
+}
+
+func stringBytes(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+func topicMatches(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+
+		if i >= len(topicLevels) {
+			return false
+		}
+
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+
+	return len(filterLevels) == len(topicLevels)
+}
@@ -0,0 +1,278 @@
+package spbtest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const testTimeout = 2 * time.Second
+
+func newTestBroker(t *testing.T) *Broker {
+	t.Helper()
+
+	b, err := NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+
+	return b
+}
+
+func connectClient(t *testing.T, b *Broker, clientID string, configure func(*mqtt.ClientOptions)) mqtt.Client {
+	t.Helper()
+
+	opts := mqtt.NewClientOptions().
+		AddBroker("tcp://" + b.Addr()).
+		SetClientID(clientID).
+		SetAutoReconnect(false).
+		SetConnectTimeout(testTimeout)
+	if configure != nil {
+		configure(opts)
+	}
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(testTimeout) || token.Error() != nil {
+		t.Fatalf("Connect(%s) error = %v", clientID, token.Error())
+	}
+	t.Cleanup(func() { client.Disconnect(0) })
+
+	return client
+}
+
+func subscribe(t *testing.T, client mqtt.Client, filter string) <-chan mqtt.Message {
+	t.Helper()
+
+	messages := make(chan mqtt.Message, 16)
+	token := client.Subscribe(filter, 1, func(_ mqtt.Client, msg mqtt.Message) {
+		messages <- msg
+	})
+	if !token.WaitTimeout(testTimeout) || token.Error() != nil {
+		t.Fatalf("Subscribe(%s) error = %v", filter, token.Error())
+	}
+
+	return messages
+}
+
+func publish(t *testing.T, client mqtt.Client, topic string, payload string, retained bool) {
+	t.Helper()
+
+	token := client.Publish(topic, 1, retained, payload)
+	if !token.WaitTimeout(testTimeout) || token.Error() != nil {
+		t.Fatalf("Publish(%s) error = %v", topic, token.Error())
+	}
+}
+
+func receive(t *testing.T, messages <-chan mqtt.Message) mqtt.Message {
+	t.Helper()
+
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for a message")
+		return nil
+	}
+}
+
+func waitUntil(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{"spBv1.0/plant/NDATA/edge-1", "spBv1.0/plant/NDATA/edge-1", true},
+		{"spBv1.0/plant/NDATA/edge-1", "spBv1.0/plant/NDATA/edge-2", false},
+		{"spBv1.0/+/NDATA/+", "spBv1.0/plant/NDATA/edge-1", true},
+		{"spBv1.0/+/NDATA/+", "spBv1.0/plant/DDATA/edge-1/pump", false},
+		{"spBv1.0/plant/DCMD/edge-1/+", "spBv1.0/plant/DCMD/edge-1/pump", true},
+		{"spBv1.0/plant/DCMD/edge-1/+", "spBv1.0/plant/DCMD/edge-1", false},
+		{"spBv1.0/plant/+", "spBv1.0/plant/NDATA/edge-1", false},
+		{"spBv1.0/#", "spBv1.0/plant/DDATA/edge-1/pump", true},
+		{"spBv1.0/#", "spBv1.0", true},
+		{"spBv1.0/plant/#", "spBv1.0/other/NDATA/edge-1", false},
+		{"#", "spBv1.0/STATE/scada", true},
+		{"spBv1.0/STATE/+", "spBv1.0/STATE/scada", true},
+		{"spBv1.0/+/+/+", "spBv1.0/STATE/scada", false},
+	}
+
+	for _, tt := range tests {
+		if got := topicMatches(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("topicMatches(%q, %q) = %t, want %t", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestBrokerRetained(t *testing.T) {
+	b := newTestBroker(t)
+	publisher := connectClient(t, b, "publisher", nil)
+
+	publish(t, publisher, "spBv1.0/STATE/scada", "online", true)
+	if payload, ok := b.Retained("spBv1.0/STATE/scada"); !ok || string(payload) != "online" {
+		t.Fatalf("Retained() = %q, %t, want online, true", payload, ok)
+	}
+
+	subscriber := connectClient(t, b, "subscriber", nil)
+	msg := receive(t, subscribe(t, subscriber, "spBv1.0/STATE/+"))
+	if msg.Topic() != "spBv1.0/STATE/scada" || string(msg.Payload()) != "online" || !msg.Retained() {
+		t.Errorf("received %s %q retained %t, want the retained STATE", msg.Topic(), msg.Payload(), msg.Retained())
+	}
+
+	publish(t, publisher, "spBv1.0/STATE/scada", "", true)
+	if _, ok := b.Retained("spBv1.0/STATE/scada"); ok {
+		t.Error("Retained() after an empty retained publish = true, want false")
+	}
+}
+
+func TestBrokerWildcards(t *testing.T) {
+	b := newTestBroker(t)
+	publisher := connectClient(t, b, "publisher", nil)
+
+	tests := []struct {
+		filter string
+		want   []string
+	}{
+		{"spBv1.0/plant/+/edge-1", []string{"spBv1.0/plant/NBIRTH/edge-1", "spBv1.0/plant/NDATA/edge-1"}},
+		{"spBv1.0/plant/DDATA/edge-1/+", []string{"spBv1.0/plant/DDATA/edge-1/pump", "spBv1.0/plant/DDATA/edge-1/valve"}},
+		{"spBv1.0/plant/#", []string{
+			"spBv1.0/plant/NBIRTH/edge-1",
+			"spBv1.0/plant/DDATA/edge-1/pump",
+			"spBv1.0/plant/DDATA/edge-1/valve",
+			"spBv1.0/plant/NDATA/edge-1",
+		}},
+	}
+
+	subscriptions := make([]<-chan mqtt.Message, len(tests))
+	for i, tt := range tests {
+		subscriptions[i] = subscribe(t, connectClient(t, b, fmt.Sprintf("subscriber-%d", i), nil), tt.filter)
+	}
+
+	for _, topic := range []string{
+		"spBv1.0/plant/NBIRTH/edge-1",
+		"spBv1.0/other/NBIRTH/edge-1",
+		"spBv1.0/plant/DDATA/edge-1/pump",
+		"spBv1.0/plant/DDATA/edge-1/valve",
+		"spBv1.0/plant/NDATA/edge-1",
+	} {
+		publish(t, publisher, topic, "", false)
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			for _, want := range tt.want {
+				if msg := receive(t, subscriptions[i]); msg.Topic() != want {
+					t.Fatalf("received %s, want %s", msg.Topic(), want)
+				}
+			}
+
+			select {
+			case msg := <-subscriptions[i]:
+				t.Errorf("received unexpected %s", msg.Topic())
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestBrokerWill(t *testing.T) {
+	tests := []struct {
+		name     string
+		drop     bool
+		wantWill bool
+	}{
+		{name: "dropped connection", drop: true, wantWill: true},
+		{name: "clean disconnect", drop: false, wantWill: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBroker(t)
+			wills := subscribe(t, connectClient(t, b, "host", nil), "spBv1.0/plant/NDEATH/+")
+
+			edge := connectClient(t, b, "edge", func(opts *mqtt.ClientOptions) {
+				opts.SetWill("spBv1.0/plant/NDEATH/edge-1", "death", 1, true)
+			})
+
+			if tt.drop {
+				if !b.DropClient("edge") {
+					t.Fatal("DropClient() = false, want true")
+				}
+			} else {
+				edge.Disconnect(250)
+				waitUntil(t, "the edge disconnects", func() bool { return !b.Connected("edge") })
+			}
+
+			if b.Connected("edge") {
+				t.Error("Connected() = true after the connection closed")
+			}
+
+			select {
+			case msg := <-wills:
+				if !tt.wantWill {
+					t.Fatalf("received unexpected will on %s", msg.Topic())
+				}
+				if string(msg.Payload()) != "death" {
+					t.Errorf("will payload = %q, want death", msg.Payload())
+				}
+			case <-time.After(200 * time.Millisecond):
+				if tt.wantWill {
+					t.Fatal("timed out waiting for the will")
+				}
+			}
+
+			if _, ok := b.Retained("spBv1.0/plant/NDEATH/edge-1"); ok != tt.wantWill {
+				t.Errorf("will retained = %t, want %t", ok, tt.wantWill)
+			}
+		})
+	}
+}
+
+func TestBrokerSessionTakeover(t *testing.T) {
+	b := newTestBroker(t)
+
+	lost := make(chan error, 1)
+	first := connectClient(t, b, "edge", func(opts *mqtt.ClientOptions) {
+		opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			lost <- err
+		})
+	})
+	subscribe(t, first, "spBv1.0/plant/NCMD/edge-1")
+
+	second := connectClient(t, b, "edge", nil)
+
+	select {
+	case <-lost:
+	case <-time.After(testTimeout):
+		t.Fatal("the first connection was not closed by the takeover")
+	}
+
+	if !b.Connected("edge") {
+		t.Fatal("Connected() = false after the takeover")
+	}
+
+	messages := subscribe(t, second, "spBv1.0/plant/NCMD/edge-1")
+	b.Publish("spBv1.0/plant/NCMD/edge-1", []byte("rebirth"), false)
+
+	if msg := receive(t, messages); string(msg.Payload()) != "rebirth" {
+		t.Errorf("received %q, want rebirth", msg.Payload())
+	}
+	if first.IsConnectionOpen() {
+		t.Error("first client is still connected after the takeover")
+	}
+}